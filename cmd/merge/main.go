@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"merged-ip-data/internal/config"
@@ -16,6 +17,14 @@ import (
 func main() {
 	skipDownload := flag.Bool("skip-download", false, "Skip downloading databases (use existing files)")
 	outputPath := flag.String("output", config.OutputFile, "Output file path")
+	asnOutputPath := flag.String("asn-output", config.ASNOutputFile, "ASN companion database output path (empty to skip)")
+	riskWeightsPath := flag.String("risk-weights", "", "JSON file overriding proxy risk score weights")
+	feedsPath := flag.String("feeds", config.FeedsFile, "JSON file declaring additional proxy/abuse flag feeds")
+	ribPaths := flag.String("rib", "", "Comma-separated pfx2as or MRT RIB files to use as an additional ASN source")
+	asnReportPath := flag.String("asn-report", "", "Compare all ASN sources and write the networks they disagree on to this CSV file")
+	sourceNetworks := flag.Bool("source-networks", false, "Record the prefix length of each contributing source in meta.source_networks")
+	proxySeenDates := flag.Bool("proxy-seen-dates", false, "Write exact first/last-seen dates of proxy observations into proxy records instead of the week last seen")
+	includeReserved := flag.Bool("include-reserved", false, "Annotate special-purpose and unallocated networks with a network_type")
 	flag.Parse()
 
 	fmt.Println("=== Merged IP Database Generator ===")
@@ -23,6 +32,49 @@ func main() {
 
 	startTime := time.Now()
 
+	if *riskWeightsPath != "" {
+		if err := config.LoadRiskWeights(*riskWeightsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Proxy risk weights loaded from %s\n", *riskWeightsPath)
+	}
+
+	// The default feeds file is optional; an explicitly given one is not
+	if err := config.LoadFeeds(*feedsPath, *feedsPath == config.FeedsFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(config.Feeds) > 0 {
+		fmt.Printf("Flag feeds loaded from %s: %d feeds\n", *feedsPath, len(config.Feeds))
+	}
+
+	for _, path := range strings.Split(*ribPaths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			config.RIBFiles = append(config.RIBFiles, path)
+		}
+	}
+
+	if *asnReportPath != "" {
+		config.ASNReportFile = *asnReportPath
+		fmt.Printf("ASN disagreement mode enabled, report: %s\n", *asnReportPath)
+	}
+
+	if *includeReserved {
+		config.IncludeReservedNetworks = true
+		fmt.Println("Reserved network annotations enabled")
+	}
+
+	if *proxySeenDates {
+		config.ProxySeenDates = true
+		fmt.Println("Proxy seen dates enabled")
+	}
+
+	if *sourceNetworks {
+		config.SourceNetworks = true
+		fmt.Println("Source network recording enabled")
+	}
+
 	if !*skipDownload {
 		if err := downloadDatabases(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error downloading databases: %v\n", err)
@@ -36,7 +88,7 @@ func main() {
 		}
 	}
 
-	if err := mergeDatabases(*outputPath); err != nil {
+	if err := mergeDatabases(*outputPath, *asnOutputPath); err != nil {
 		fmt.Fprintf(os.Stderr, "Error merging databases: %v\n", err)
 		os.Exit(1)
 	}
@@ -56,7 +108,10 @@ func downloadDatabases() error {
 
 	fmt.Println("\nDownload Results:")
 	for _, result := range results {
-		if result.Error != nil {
+		if result.Error != nil && result.Source.Optional {
+			fmt.Printf("  [SKIP] %s (optional): %v\n", result.Source.Name, result.Error)
+			warnStale(result.Source)
+		} else if result.Error != nil {
 			fmt.Printf("  [FAIL] %s: %v\n", result.Source.Name, result.Error)
 		} else {
 			fmt.Printf("  [OK] %s\n", result.Source.Name)
@@ -71,7 +126,20 @@ func downloadDatabases() error {
 	return nil
 }
 
-func mergeDatabases(outputPath string) error {
+// warnStale reports the age of the file an optional source falls back to
+// after a failed download, since the merge silently uses it otherwise
+func warnStale(source config.DatabaseSource) {
+	info, err := os.Stat(source.Path)
+	if err != nil {
+		fmt.Printf("  Warning: %s has no earlier copy at %s and will be missing\n", source.Name, source.Path)
+		return
+	}
+	modified := info.ModTime()
+	fmt.Printf("  Warning: %s falls back to %s, last updated %s (%s ago)\n", source.Name, source.Path,
+		modified.UTC().Format(time.RFC3339), time.Since(modified).Round(time.Hour))
+}
+
+func mergeDatabases(outputPath, asnOutputPath string) error {
 	fmt.Println("=== Merging Databases ===")
 
 	m, err := merger.New()
@@ -89,5 +157,11 @@ func mergeDatabases(outputPath string) error {
 		return fmt.Errorf("failed to write output: %w", err)
 	}
 
+	if asnOutputPath != "" {
+		if err := m.WriteASNDatabase(asnOutputPath); err != nil {
+			return fmt.Errorf("failed to write ASN database: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -6,10 +6,10 @@ require (
 	github.com/ipipdotnet/ipdb-go v1.3.3
 	github.com/maxmind/mmdbwriter v1.2.0
 	github.com/oschwald/maxminddb-golang v1.13.1
+	go4.org/netipx v0.0.0-20231129151722-fdeea329fbba
 )
 
 require (
 	github.com/oschwald/maxminddb-golang/v2 v2.1.1 // indirect
-	go4.org/netipx v0.0.0-20231129151722-fdeea329fbba // indirect
 	golang.org/x/sys v0.38.0 // indirect
 )
@@ -1,5 +1,14 @@
 package config
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
 // Database download URLs
 const (
 	GeoLite2CityURL    = "https://github.com/P3TERX/GeoLite.mmdb/releases/latest/download/GeoLite2-City.mmdb"
@@ -12,12 +21,32 @@ const (
 	QQWryURL           = "https://cdn.jsdelivr.net/npm/qqwry.ipdb/qqwry.ipdb"
 	OpenproxyDBURL     = "https://github.com/NetworkCats/OpenProxyDB/releases/latest/download/proxy_blocks.csv"
 	BadIPListURL       = "https://github.com/NetworkCats/badiplist/releases/latest/download/badiplist.txt"
-	TorRelaysURL       = "https://onionoo.torproject.org/details?type=relay&running=true&fields=or_addresses,exit_addresses"
+	TorRelaysURL       = "https://onionoo.torproject.org/details?type=relay&running=true&fields=or_addresses,exit_addresses,flags,exit_policy_summary,first_seen,last_seen"
 	AnycastV4URL       = "https://raw.githubusercontent.com/bgptools/anycast-prefixes/refs/heads/master/anycatch-v4-prefixes.txt"
 	AnycastV6URL       = "https://raw.githubusercontent.com/bgptools/anycast-prefixes/refs/heads/master/anycatch-v6-prefixes.txt"
 	BadASNListURL      = "https://raw.githubusercontent.com/brianhama/bad-asn-list/refs/heads/master/bad-asn-list.csv"
 )
 
+// Cloud provider published IP range URLs. Azure publishes its service tags
+// under a new URL every week, so there is no stable URL to download; place
+// the current ServiceTags_Public file at AzureServiceTagsFile to use it.
+const (
+	AWSIPRangesURL    = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+	GCPCloudRangesURL = "https://www.gstatic.com/ipranges/cloud.json"
+	OracleIPRangesURL = "https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json"
+	CloudflareV4URL   = "https://www.cloudflare.com/ips-v4"
+	CloudflareV6URL   = "https://www.cloudflare.com/ips-v6"
+)
+
+// RIR delegated-extended statistics URLs, updated daily by each registry
+const (
+	ARINDelegatedURL    = "https://ftp.arin.net/pub/stats/arin/delegated-arin-extended-latest"
+	RIPEDelegatedURL    = "https://ftp.ripe.net/pub/stats/ripencc/delegated-ripencc-extended-latest"
+	APNICDelegatedURL   = "https://ftp.apnic.net/stats/apnic/delegated-apnic-extended-latest"
+	LACNICDelegatedURL  = "https://ftp.lacnic.net/pub/stats/lacnic/delegated-lacnic-extended-latest"
+	AFRINICDelegatedURL = "https://ftp.afrinic.net/pub/stats/afrinic/delegated-afrinic-extended-latest"
+)
+
 // Local file paths for downloaded databases
 const (
 	GeoLite2CityFile    = "download/GeoLite2-City.mmdb"
@@ -28,19 +57,76 @@ const (
 	RouteViewsASNFile   = "download/routeviews-asn.mmdb"
 	GeoWhoisCountryFile = "download/geolite2-geo-whois-asn-country.mmdb"
 	QQWryFile           = "download/qqwry.ipdb"
+	QQWryIPv6File       = "download/qqwry-ipv6.ipdb" // optional, placed manually
 	OpenproxyDBFile     = "download/proxy_blocks.csv"
 	BadIPListFile       = "download/badiplist.txt"
 	TorRelaysFile       = "download/tor_relays.json"
 	AnycastV4File       = "download/anycast-v4.txt"
 	AnycastV6File       = "download/anycast-v6.txt"
 	BadASNListFile      = "download/bad-asn-list.csv"
+
+	AWSIPRangesFile      = "download/aws-ip-ranges.json"
+	GCPCloudRangesFile   = "download/gcp-cloud.json"
+	AzureServiceTagsFile = "download/azure-service-tags.json"
+	OracleIPRangesFile   = "download/oracle-public-ip-ranges.json"
+	CloudflareV4File     = "download/cloudflare-ips-v4.txt"
+	CloudflareV6File     = "download/cloudflare-ips-v6.txt"
+
+	ARINDelegatedFile    = "download/delegated-arin-extended-latest"
+	RIPEDelegatedFile    = "download/delegated-ripencc-extended-latest"
+	APNICDelegatedFile   = "download/delegated-apnic-extended-latest"
+	LACNICDelegatedFile  = "download/delegated-lacnic-extended-latest"
+	AFRINICDelegatedFile = "download/delegated-afrinic-extended-latest"
 )
 
-// Output file path
+// Local override files. All are optional and are applied after every
+// upstream source, so their contents win over upstream data.
 const (
-	OutputFile = "Merged-IP.mmdb"
+	OverrideBadASNFile        = "overrides/bad-asns.csv"
+	OverrideProxyPrefixesFile = "overrides/proxy-prefixes.csv"
+	OverrideGeoPrefixesFile   = "overrides/geo-prefixes.csv"
+	OverrideASOrgFile         = "overrides/as-orgs.csv"
+	OverrideAllowlistFile     = "overrides/allowlist.csv"
 )
 
+// Output file paths
+const (
+	OutputFile    = "Merged-IP.mmdb"
+	ASNOutputFile = "Merged-ASN.json"
+)
+
+// FeedsFile is the optional JSON file declaring additional flag feeds
+const FeedsFile = "feeds.json"
+
+// ConnectionASNsFile is the optional CSV listing mobile carrier and other
+// ASNs by connection type, as "asn[,connection_type]" rows
+const ConnectionASNsFile = "connection-asns.csv"
+
+// ASNReportFile enables the ASN disagreement mode when set: every network is
+// looked up in all ASN sources, and those the sources disagree on are
+// written to this CSV file. Empty (the default) disables the mode.
+var ASNReportFile string
+
+// RIBFiles lists local CAIDA pfx2as files or MRT TABLE_DUMP_V2 RIB dumps
+// (optionally gzip or bzip2 compressed) used as an additional ASN source
+// after RouteViews. Empty (the default) disables the source.
+var RIBFiles []string
+
+// IncludeReservedNetworks enables annotating IANA special-purpose ranges and
+// unallocated address space with a network_type instead of leaving them out
+// of the database. Disabled by default.
+var IncludeReservedNetworks bool
+
+// SourceNetworks enables writing meta.source_networks, the prefix length of
+// the network each contributing source answered from. Disabled by default.
+var SourceNetworks bool
+
+// ProxySeenDates enables writing proxy.first_seen and the exact day of
+// proxy.last_seen. Without it only last_seen is written, rounded down to the
+// Monday of its week, since exact dates differ from address to address and
+// keep records from being deduplicated in the database. Disabled by default.
+var ProxySeenDates bool
+
 // Supported languages for multi-language names
 var SupportedLanguages = []string{
 	"de",    // German
@@ -67,16 +153,191 @@ const (
 	DownloadConcurrency = 7
 )
 
-// DatabaseSource represents a database source with its URL and local path
+// ProxyRiskWeights controls how proxy.risk_score is derived. Every signal
+// present on a record adds its weight and the total is capped at 100. A Tor
+// exit counts TorExit only; other relays count TorRelay.
+type ProxyRiskWeights struct {
+	OpenProxy  int `json:"open_proxy"` // OpenProxyDB "proxy" category
+	BadIPList  int `json:"badiplist"`
+	Anonblock  int `json:"anonblock"`
+	Rangeblock int `json:"rangeblock"`
+	VPN        int `json:"vpn"`
+	TorExit    int `json:"tor_exit"`
+	TorRelay   int `json:"tor_relay"`
+	Hosting    int `json:"hosting"`
+	CDN        int `json:"cdn"` // OpenProxyDB cdn category or bgp.tools anycast
+	School     int `json:"school"`
+
+	// Bad-ASN list membership: BadASN for an uncategorized listing, the
+	// others per category the ASN is listed under
+	BadASN            int `json:"bad_asn"`
+	BadASNHosting     int `json:"bad_asn_hosting"`
+	BadASNVPN         int `json:"bad_asn_vpn"`
+	BadASNBulletproof int `json:"bad_asn_bulletproof"`
+}
+
+// RiskWeights is the weighting used for proxy.risk_score. It can be
+// replaced at startup with LoadRiskWeights.
+var RiskWeights = ProxyRiskWeights{
+	OpenProxy:  80,
+	BadIPList:  60,
+	Anonblock:  50,
+	Rangeblock: 30,
+	VPN:        70,
+	TorExit:    90,
+	TorRelay:   40,
+	Hosting:    25,
+	CDN:        5,
+	School:     10,
+
+	BadASN:            25,
+	BadASNHosting:     25,
+	BadASNVPN:         50,
+	BadASNBulletproof: 70,
+}
+
+// LoadRiskWeights replaces RiskWeights with the JSON object in the file at
+// path. Keys missing from the file keep their default weight; unknown keys
+// are an error, so a misspelled weight is not silently ignored.
+func LoadRiskWeights(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read risk weights: %w", err)
+	}
+	weights := RiskWeights
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&weights); err != nil {
+		return fmt.Errorf("failed to parse risk weights: %w", err)
+	}
+	RiskWeights = weights
+	return nil
+}
+
+// FeedSource declares a flag feed: a downloaded list of IPs and prefixes
+// whose every entry gets the same proxy flags. Name is reported in
+// proxy.sources.
+type FeedSource struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Path string `json:"path,omitempty"` // local file; defaults to download/feed-<name>
+
+	// Format is one of "ip" (one address per line, prefixes rejected),
+	// "cidr" (one prefix or address per line, as in FireHOL netsets or
+	// Spamhaus DROP), "csv" or "json". Defaults to "cidr".
+	Format string `json:"format,omitempty"`
+
+	// Field selects the entry in each CSV row (header name or 0-based index)
+	// or in each JSON object (key). JSONPath is the dot-separated path to
+	// the entries in a JSON document; arrays along the way are flattened.
+	Field    string `json:"field,omitempty"`
+	JSONPath string `json:"json_path,omitempty"`
+
+	// Flags names the proxy flags set on every entry, e.g. "proxy;hosting"
+	Flags string `json:"flags"`
+
+	// Expiry, e.g. "72h" or "30d", drops entries whose DateField is older.
+	// It requires a DateField: the file is rewritten by every download, so
+	// its modification time says nothing about the age of the entries.
+	Expiry    string `json:"expiry,omitempty"`
+	DateField string `json:"date_field,omitempty"`
+
+	// Weight is added to proxy.risk_score for addresses listed by the feed
+	Weight int `json:"weight,omitempty"`
+}
+
+// AnycastFeedName is the name of the built-in bgp.tools anycast feed
+const AnycastFeedName = "anycast"
+
+// BuiltinFeeds are the flag feeds always loaded, in load order
+var BuiltinFeeds = []FeedSource{
+	{Name: "badiplist", URL: BadIPListURL, Path: BadIPListFile, Format: "ip", Flags: "proxy"},
+	{Name: AnycastFeedName, URL: AnycastV4URL, Path: AnycastV4File, Format: "cidr", Flags: "cdn"},
+	{Name: AnycastFeedName, URL: AnycastV6URL, Path: AnycastV6File, Format: "cidr", Flags: "cdn"},
+}
+
+// Feeds holds the additional flag feeds loaded with LoadFeeds
+var Feeds []FeedSource
+
+// LoadFeeds reads the JSON array of feeds in the file at path into Feeds.
+// A missing file is not an error when optional is set.
+func LoadFeeds(path string, optional bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read feeds: %w", err)
+	}
+
+	var feeds []FeedSource
+	if err := json.Unmarshal(data, &feeds); err != nil {
+		return fmt.Errorf("failed to parse feeds: %w", err)
+	}
+	names := make(map[string]bool)
+	paths := make(map[string]string)
+	for i := range feeds {
+		if feeds[i].Name == "" || feeds[i].URL == "" {
+			return fmt.Errorf("feed %d: name and url are required", i)
+		}
+		if !validFeedName(feeds[i].Name) {
+			return fmt.Errorf("feed %q: name may only contain a-z, 0-9, '_' and '-'", feeds[i].Name)
+		}
+		if slices.Contains(reservedFeedNames, feeds[i].Name) {
+			return fmt.Errorf("feed %s: name is used by a built-in source", feeds[i].Name)
+		}
+		if names[feeds[i].Name] {
+			return fmt.Errorf("feed %s: declared twice", feeds[i].Name)
+		}
+		names[feeds[i].Name] = true
+		if feeds[i].Expiry != "" && feeds[i].DateField == "" {
+			return fmt.Errorf("feed %s: expiry requires date_field", feeds[i].Name)
+		}
+		if feeds[i].Path == "" {
+			feeds[i].Path = "download/feed-" + feeds[i].Name
+		}
+		if other, ok := paths[feeds[i].Path]; ok {
+			return fmt.Errorf("feed %s: path %s is also used by feed %s", feeds[i].Name, feeds[i].Path, other)
+		}
+		paths[feeds[i].Path] = feeds[i].Name
+	}
+	Feeds = feeds
+	return nil
+}
+
+// reservedFeedNames are the names of the built-in proxy sources reported in
+// proxy.sources, which a loaded feed must not reuse
+var reservedFeedNames = []string{"openproxydb", "badiplist", "tor", AnycastFeedName, "bad_asn", "override", "cloud"}
+
+// validFeedName reports whether name only has the characters allowed in a
+// feed name, which also names its default download file
+func validFeedName(name string) bool {
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// AllFeeds returns the built-in feeds followed by the loaded ones
+func AllFeeds() []FeedSource {
+	return append(append([]FeedSource(nil), BuiltinFeeds...), Feeds...)
+}
+
+// DatabaseSource represents a database source with its URL and local path.
+// A failed download of an optional source is reported but does not abort the
+// run, and its file may be missing.
 type DatabaseSource struct {
-	Name string
-	URL  string
-	Path string
+	Name     string
+	URL      string
+	Path     string
+	Optional bool
 }
 
 // GetAllSources returns all database sources for downloading
 func GetAllSources() []DatabaseSource {
-	return []DatabaseSource{
+	sources := []DatabaseSource{
 		{Name: "GeoLite2-City", URL: GeoLite2CityURL, Path: GeoLite2CityFile},
 		{Name: "GeoLite2-ASN", URL: GeoLite2ASNURL, Path: GeoLite2ASNFile},
 		{Name: "IPinfo-Lite", URL: IPinfoLiteURL, Path: IPinfoLiteFile},
@@ -91,5 +352,19 @@ func GetAllSources() []DatabaseSource {
 		{Name: "Anycast-V4", URL: AnycastV4URL, Path: AnycastV4File},
 		{Name: "Anycast-V6", URL: AnycastV6URL, Path: AnycastV6File},
 		{Name: "BadASNList", URL: BadASNListURL, Path: BadASNListFile},
+		{Name: "AWS-IP-Ranges", URL: AWSIPRangesURL, Path: AWSIPRangesFile, Optional: true},
+		{Name: "GCP-IP-Ranges", URL: GCPCloudRangesURL, Path: GCPCloudRangesFile, Optional: true},
+		{Name: "Oracle-IP-Ranges", URL: OracleIPRangesURL, Path: OracleIPRangesFile, Optional: true},
+		{Name: "Cloudflare-V4", URL: CloudflareV4URL, Path: CloudflareV4File, Optional: true},
+		{Name: "Cloudflare-V6", URL: CloudflareV6URL, Path: CloudflareV6File, Optional: true},
+		{Name: "ARIN-Delegated", URL: ARINDelegatedURL, Path: ARINDelegatedFile, Optional: true},
+		{Name: "RIPE-Delegated", URL: RIPEDelegatedURL, Path: RIPEDelegatedFile, Optional: true},
+		{Name: "APNIC-Delegated", URL: APNICDelegatedURL, Path: APNICDelegatedFile, Optional: true},
+		{Name: "LACNIC-Delegated", URL: LACNICDelegatedURL, Path: LACNICDelegatedFile, Optional: true},
+		{Name: "AFRINIC-Delegated", URL: AFRINICDelegatedURL, Path: AFRINICDelegatedFile, Optional: true},
+	}
+	for _, feed := range Feeds {
+		sources = append(sources, DatabaseSource{Name: "Feed-" + feed.Name, URL: feed.URL, Path: feed.Path})
 	}
+	return sources
 }
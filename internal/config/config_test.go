@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFeeds(t *testing.T) {
+	tests := []struct {
+		name    string
+		feeds   string
+		wantErr bool
+	}{
+		{"valid", `[{"name": "spamhaus_drop", "url": "https://example.com/a"}, {"name": "in-house", "url": "https://example.com/b"}]`, false},
+		{"missing url", `[{"name": "a"}]`, true},
+		{"expiry without date_field", `[{"name": "a", "url": "https://example.com/a", "expiry": "30d"}]`, true},
+		{"duplicate name", `[{"name": "a", "url": "https://example.com/a"}, {"name": "a", "url": "https://example.com/b"}]`, true},
+		{"built-in name", `[{"name": "tor", "url": "https://example.com/a"}]`, true},
+		{"path traversal", `[{"name": "../x", "url": "https://example.com/a"}]`, true},
+		{"upper case", `[{"name": "Drop", "url": "https://example.com/a"}]`, true},
+		{"shared path", `[{"name": "a", "url": "https://example.com/a", "path": "feeds/x"}, {"name": "b", "url": "https://example.com/b", "path": "feeds/x"}]`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "feeds.json")
+			if err := os.WriteFile(path, []byte(tt.feeds), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			Feeds = nil
+			err := LoadFeeds(path, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFeeds() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	Feeds = nil
+}
@@ -76,7 +76,7 @@ func (d *Downloader) DownloadAll(ctx context.Context) ([]Result, error) {
 
 	var failedCount int
 	for _, result := range results {
-		if result.Error != nil {
+		if result.Error != nil && !result.Source.Optional {
 			failedCount++
 		}
 	}
@@ -176,12 +176,15 @@ func (d *Downloader) download(ctx context.Context, source config.DatabaseSource)
 	return nil
 }
 
-// VerifyFiles checks that all required database files exist
+// VerifyFiles checks that all required (non-optional) database files exist
 func VerifyFiles() error {
 	sources := config.GetAllSources()
 	var missing []string
 
 	for _, source := range sources {
+		if source.Optional {
+			continue
+		}
 		if _, err := os.Stat(source.Path); os.IsNotExist(err) {
 			missing = append(missing, source.Path)
 		}
@@ -0,0 +1,27 @@
+package merger
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// anycastOperator names the organization announcing an anycast prefix,
+// resolved through the same ASN priority chain (and AS organization
+// overrides) as the merged records. Falls back to "AS<number>" when the ASN
+// has no organization name, and returns "" when no source knows the prefix.
+// Resolution runs before the merge on a context of its own, which keeps its
+// lookups out of the merge statistics.
+func (ctx *workerContext) anycastOperator(prefix netip.Prefix) string {
+	var record MergedRecord
+	ctx.enrichWithASNData(prefix.Addr().AsSlice(), &record)
+	ctx.fillASNNames(&record)
+	applyASOrgOverride(ctx.overrides, &record.ASN)
+
+	if record.ASN.Organization != "" {
+		return record.ASN.Organization
+	}
+	if record.ASN.Number != 0 {
+		return fmt.Sprintf("AS%d", record.ASN.Number)
+	}
+	return ""
+}
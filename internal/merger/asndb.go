@@ -0,0 +1,289 @@
+package merger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"merged-ip-data/internal/reader"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+)
+
+// asnSummary accumulates what the merge observes about one ASN
+type asnSummary struct {
+	organization  string
+	domain        string
+	networks      int64 // merged networks, not routed prefixes
+	ipv4Addresses uint64
+	ipv6Blocks    uint64 // address space in /64 units
+	countries     map[string]int64
+}
+
+// asnSummaries collects an asnSummary per ASN seen in merged records
+type asnSummaries map[uint32]*asnSummary
+
+// observe adds a merged network to the summary of its ASN
+func (s asnSummaries) observe(network *net.IPNet, record *MergedRecord) {
+	prefix, ok := networkPrefix(network)
+	if !ok {
+		return
+	}
+	ipv4, ipv6Blocks := prefixSize(prefix)
+	s.observeSize(record, ipv4, ipv6Blocks)
+}
+
+// observeSize adds a merged network of the given size to the summary of its
+// ASN, for networks only part of which carries the record's ASN
+func (s asnSummaries) observeSize(record *MergedRecord, ipv4 uint64, ipv6Blocks uint64) {
+	if record.ASN.Number == 0 {
+		return
+	}
+
+	summary := s[record.ASN.Number]
+	if summary == nil {
+		summary = &asnSummary{countries: make(map[string]int64)}
+		s[record.ASN.Number] = summary
+	}
+	if summary.organization == "" {
+		summary.organization = record.ASN.Organization
+	}
+	if summary.domain == "" {
+		summary.domain = record.ASN.Domain
+	}
+	if record.Country.ISOCode != "" {
+		summary.countries[record.Country.ISOCode]++
+	}
+
+	summary.networks++
+	summary.ipv4Addresses = saturatingAdd(summary.ipv4Addresses, ipv4)
+	summary.ipv6Blocks = saturatingAdd(summary.ipv6Blocks, ipv6Blocks)
+}
+
+// networkPrefix converts a network as read from a MaxMind DB, where IPv4
+// networks may carry a 16-byte mask, to an unmapped prefix
+func networkPrefix(network *net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, maskBits := network.Mask.Size()
+	if addr = addr.Unmap(); addr.Is4() {
+		ones -= maskBits - 32
+	}
+	if ones < 0 {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones).Masked(), true
+}
+
+// prefixSize returns the number of addresses in an IPv4 prefix, or the
+// number of /64 blocks in an IPv6 prefix, saturating at the largest uint64
+// for ::/0. IPv6 prefixes longer than /64 count as no block.
+func prefixSize(prefix netip.Prefix) (ipv4 uint64, ipv6Blocks uint64) {
+	if prefix.Addr().Is4() {
+		return 1 << (32 - prefix.Bits()), 0
+	}
+	switch {
+	case prefix.Bits() > 64:
+		return 0, 0
+	case prefix.Bits() == 0:
+		return 0, math.MaxUint64
+	}
+	return 0, 1 << (64 - prefix.Bits())
+}
+
+// saturatingAdd returns a+b, or the largest uint64 if the sum overflows
+func saturatingAdd(a, b uint64) uint64 {
+	if sum, carry := bits.Add64(a, b, 0); carry == 0 {
+		return sum
+	}
+	return math.MaxUint64
+}
+
+// sizeWithoutASN returns the size, as prefixSize counts it, of the parts of
+// network whose records in the tree have no ASN yet. Those are the parts
+// where insertWithMerge will give the network's record its ASN.
+func (m *Merger) sizeWithoutASN(network *net.IPNet) (ipv4 uint64, ipv6Blocks uint64) {
+	prefix, ok := networkPrefix(network)
+	if !ok {
+		return 0, 0
+	}
+	last := netipx.PrefixLastIP(prefix)
+
+	for addr := prefix.Addr(); ; {
+		leafNetwork, value := m.tree.Get(addr.AsSlice())
+		ones, _ := leafNetwork.Mask.Size()
+		if addr.Is4() {
+			// IPv4 leaves are found under ::/96
+			ones = max(ones-96, 0)
+		}
+		part := netip.PrefixFrom(addr, max(ones, prefix.Bits())).Masked()
+
+		existing, _ := value.(mmdbtype.Map)
+		if _, hasASN := existing[keyASN]; !hasASN {
+			v4, v6 := prefixSize(part)
+			ipv4 = saturatingAdd(ipv4, v4)
+			ipv6Blocks = saturatingAdd(ipv6Blocks, v6)
+		}
+
+		partLast := netipx.PrefixLastIP(part)
+		if partLast.Compare(last) >= 0 {
+			return ipv4, ipv6Blocks
+		}
+		addr = partLast.Next()
+	}
+}
+
+// merge adds every summary in other into s
+func (s asnSummaries) merge(other asnSummaries) {
+	for asn, o := range other {
+		summary := s[asn]
+		if summary == nil {
+			s[asn] = o
+			continue
+		}
+		if summary.organization == "" {
+			summary.organization = o.organization
+		}
+		if summary.domain == "" {
+			summary.domain = o.domain
+		}
+		summary.networks += o.networks
+		summary.ipv4Addresses += o.ipv4Addresses
+		summary.ipv6Blocks += o.ipv6Blocks
+		for country, n := range o.countries {
+			summary.countries[country] += n
+		}
+	}
+}
+
+// country returns the country of most of the ASN's networks, ties broken
+// alphabetically
+func (s *asnSummary) country() string {
+	best := ""
+	for country, n := range s.countries {
+		if best == "" || n > s.countries[best] || (n == s.countries[best] && country < best) {
+			best = country
+		}
+	}
+	return best
+}
+
+// ASNEntry is the Merged-ASN.json record of one AS number
+type ASNEntry struct {
+	Organization     string   `json:"organization,omitempty"`
+	Domain           string   `json:"domain,omitempty"`
+	Country          string   `json:"country,omitempty"`
+	IsBadASN         bool     `json:"is_bad_asn"`
+	BadASNCategories []string `json:"bad_asn_categories,omitempty"`
+	NetworkCount     int64    `json:"network_count"`
+	IPv4AddressCount uint64   `json:"ipv4_address_count"`
+	IPv6Slash64Count uint64   `json:"ipv6_slash64_count"`
+}
+
+// WriteASNDatabase writes the ASN companion database to path: a JSON object
+// keyed by AS number, in numeric order, covering every ASN seen in the
+// merged records plus every listed bad ASN. Network counts and address space
+// are those of the merged networks attributed to the ASN: a routed prefix
+// split along geolocation boundaries counts once per part. Must be called
+// after Merge.
+func (m *Merger) WriteASNDatabase(path string) error {
+	entries := make(map[uint32]*ASNEntry, len(m.asnSummaries))
+	for asn, summary := range m.asnSummaries {
+		entries[asn] = &ASNEntry{
+			Organization:     summary.organization,
+			Domain:           summary.domain,
+			Country:          summary.country(),
+			NetworkCount:     summary.networks,
+			IPv4AddressCount: summary.ipv4Addresses,
+			IPv6Slash64Count: summary.ipv6Blocks,
+		}
+	}
+	m.badASN.ForEach(func(asn uint32, listed reader.BadASNEntry) {
+		entry := entries[asn]
+		if entry == nil {
+			entry = &ASNEntry{}
+			if name, ok := m.asnNames.Lookup(asn); ok {
+				entry.Organization = name.Organization
+				entry.Domain = name.Domain
+			}
+			entries[asn] = entry
+		}
+		if entry.Organization == "" {
+			entry.Organization = listed.Entity
+		}
+		entry.IsBadASN = true
+		entry.BadASNCategories = listed.Category.Names()
+	})
+
+	asns := make([]uint32, 0, len(entries))
+	for asn := range entries {
+		asns = append(asns, asn)
+	}
+	slices.Sort(asns)
+
+	fmt.Printf("Writing ASN database to %s...\n", path)
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create ASN database: %w", err)
+	}
+
+	// Written entry by entry: encoding/json would order the keys as strings
+	w := bufio.NewWriter(file)
+	err = writeASNEntries(w, asns, entries)
+	if flushErr := w.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if closeErr := file.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write ASN database: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename ASN database: %w", err)
+	}
+
+	fmt.Printf("ASN database written: %d ASNs\n", len(asns))
+	return nil
+}
+
+// writeASNEntries writes entries as a JSON object with one ASN per line
+func writeASNEntries(w *bufio.Writer, asns []uint32, entries map[uint32]*ASNEntry) error {
+	w.WriteString("{")
+	for i, asn := range asns {
+		data, err := json.Marshal(entries[asn])
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString("\n  \"")
+		w.WriteString(strconv.FormatUint(uint64(asn), 10))
+		w.WriteString("\": ")
+		w.Write(data)
+	}
+	_, err := w.WriteString("\n}\n")
+	return err
+}
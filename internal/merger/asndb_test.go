@@ -0,0 +1,74 @@
+package merger
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestPrefixSize(t *testing.T) {
+	tests := []struct {
+		prefix     string
+		ipv4       uint64
+		ipv6Blocks uint64
+	}{
+		{"8.8.8.0/24", 256, 0},
+		{"0.0.0.0/0", 1 << 32, 0},
+		{"2001:db8::/32", 0, 1 << 32},
+		{"2001:db8::/64", 0, 1},
+		{"2001:db8::/96", 0, 0},
+		{"::/1", 0, 1 << 63},
+		{"::/0", 0, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		ipv4, ipv6Blocks := prefixSize(netip.MustParsePrefix(tt.prefix))
+		if ipv4 != tt.ipv4 || ipv6Blocks != tt.ipv6Blocks {
+			t.Errorf("prefixSize(%s) = %d, %d, want %d, %d", tt.prefix, ipv4, ipv6Blocks, tt.ipv4, tt.ipv6Blocks)
+		}
+	}
+}
+
+func TestSizeWithoutASN(t *testing.T) {
+	withASN := mmdbtype.Map{keyASN: mmdbtype.Map{keyASNumber: mmdbtype.Uint32(15169)}}
+	geoOnly := mmdbtype.Map{keyCountry: mmdbtype.Map{keyISOCode: mmdbtype.String("US")}}
+
+	tests := []struct {
+		name       string
+		existing   map[string]mmdbtype.Map
+		network    string
+		ipv4       uint64
+		ipv6Blocks uint64
+	}{
+		{"empty tree", nil, "8.8.8.0/24", 256, 0},
+		{"record without ASN", map[string]mmdbtype.Map{"8.8.0.0/16": geoOnly}, "8.8.8.0/24", 256, 0},
+		{"covered by ASN", map[string]mmdbtype.Map{"8.8.0.0/16": withASN}, "8.8.8.0/24", 0, 0},
+		{"half covered", map[string]mmdbtype.Map{"8.8.8.0/25": withASN}, "8.8.8.0/24", 128, 0},
+		{"mixed", map[string]mmdbtype.Map{"8.8.8.0/26": withASN, "8.8.8.64/26": geoOnly}, "8.8.8.0/24", 192, 0},
+		{"ipv6", map[string]mmdbtype.Map{"2001:4860::/33": withASN}, "2001:4860::/32", 0, 1 << 31},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := mmdbwriter.New(mmdbwriter.Options{IPVersion: 6, RecordSize: 28})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for network, record := range tt.existing {
+				if err := tree.Insert(prefixToIPNet(netip.MustParsePrefix(network)), record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := &Merger{tree: tree}
+
+			_, network, _ := net.ParseCIDR(tt.network)
+			ipv4, ipv6Blocks := m.sizeWithoutASN(network)
+			if ipv4 != tt.ipv4 || ipv6Blocks != tt.ipv6Blocks {
+				t.Errorf("sizeWithoutASN(%s) = %d, %d, want %d, %d", tt.network, ipv4, ipv6Blocks, tt.ipv4, tt.ipv6Blocks)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package merger
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// asnDisagreement records the ASN each source gives a network the sources
+// disagree on; 0 (or no RIB origins) means the source does not know the
+// network
+type asnDisagreement struct {
+	network    netip.Prefix
+	selected   uint32
+	ipinfo     uint32
+	geoLite    uint32
+	routeViews uint32
+	rib        []uint32 // all origins of a MOAS prefix
+}
+
+// findASNDisagreement reports whether the sources that know the network give
+// it more than one distinct ASN, e.g. because of a hijack, stale data or a
+// prefix originated by multiple ASes
+func findASNDisagreement(network *net.IPNet, selected, ipinfo, geoLite, routeViews uint32, rib []uint32) (asnDisagreement, bool) {
+	d := asnDisagreement{selected: selected, ipinfo: ipinfo, geoLite: geoLite, routeViews: routeViews, rib: rib}
+	if len(d.alternates()) == 0 {
+		return d, false
+	}
+	if prefix, ok := netipx.FromStdIPNet(network); ok {
+		d.network = prefix.Masked()
+	}
+	return d, true
+}
+
+// alternates returns the distinct ASNs other than the selected one, in
+// source priority order
+func (d *asnDisagreement) alternates() []uint32 {
+	var result []uint32
+	for _, asn := range append([]uint32{d.ipinfo, d.geoLite, d.routeViews}, d.rib...) {
+		if asn != 0 && asn != d.selected && !slices.Contains(result, asn) {
+			result = append(result, asn)
+		}
+	}
+	return result
+}
+
+// writeASNReport writes the networks the ASN sources disagree on to path as
+// CSV, in address order. Sources without an answer are left blank.
+func writeASNReport(path string, disagreements []asnDisagreement) error {
+	slices.SortFunc(disagreements, func(a, b asnDisagreement) int {
+		return a.network.Addr().Compare(b.network.Addr())
+	})
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create ASN report: %w", err)
+	}
+	defer file.Close()
+
+	buf := bufio.NewWriter(file)
+	w := csv.NewWriter(buf)
+
+	asnField := func(asn uint32) string {
+		if asn == 0 {
+			return ""
+		}
+		return strconv.FormatUint(uint64(asn), 10)
+	}
+
+	// A MOAS prefix has several origins, separated by ';'
+	ribField := func(origins []uint32) string {
+		fields := make([]string, len(origins))
+		for i, asn := range origins {
+			fields[i] = asnField(asn)
+		}
+		return strings.Join(fields, ";")
+	}
+
+	if err := w.Write([]string{"network", "selected_asn", "ipinfo_asn", "geolite_asn", "routeviews_asn", "rib_asns"}); err != nil {
+		return fmt.Errorf("failed to write ASN report: %w", err)
+	}
+	for _, d := range disagreements {
+		row := []string{
+			d.network.String(),
+			asnField(d.selected),
+			asnField(d.ipinfo),
+			asnField(d.geoLite),
+			asnField(d.routeViews),
+			ribField(d.rib),
+		}
+		if err := w.Write(row); err != nil {
+			return fmt.Errorf("failed to write ASN report: %w", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write ASN report: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write ASN report: %w", err)
+	}
+	return file.Close()
+}
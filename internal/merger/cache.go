@@ -0,0 +1,162 @@
+package merger
+
+import (
+	"net"
+	"net/netip"
+
+	"merged-ip-data/internal/reader"
+
+	"go4.org/netipx"
+)
+
+// rangeCache remembers the last answer of one source together with the
+// network that answer holds for. Networks are merged in address order, so
+// the next lookup usually falls in the same source network and skips the
+// source entirely.
+type rangeCache[T any] struct {
+	entry  cacheEntry[T]
+	hits   int64
+	misses int64
+}
+
+// cacheEntry is one answer of a source: found reports whether the source has
+// a record for network, and value is that record
+type cacheEntry[T any] struct {
+	network netip.Prefix
+	value   T
+	found   bool
+}
+
+// lookup returns the answer for ip, calling load on a cache miss. load fills
+// value and returns the network its answer holds for, or an invalid prefix
+// when the answer must not be reused.
+func (c *rangeCache[T]) lookup(ip net.IP, load func(ip net.IP, value *T) (netip.Prefix, bool)) *cacheEntry[T] {
+	if addr, ok := netip.AddrFromSlice(ip); ok && c.entry.network.Contains(addr.Unmap()) {
+		c.hits++
+		return &c.entry
+	}
+
+	c.misses++
+	var zero T
+	c.entry.value = zero
+	c.entry.network, c.entry.found = load(ip, &c.entry.value)
+	return &c.entry
+}
+
+// stats returns the hit and miss counts of the cache
+func (c *rangeCache[T]) stats() CacheStats {
+	return CacheStats{Hits: c.hits, Misses: c.misses}
+}
+
+// CacheStats counts the lookups a range cache answered itself (Hits) and
+// passed on to its source (Misses)
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// HitRate returns the fraction of lookups answered from the cache
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// add adds the counts of other to s
+func (s *CacheStats) add(other CacheStats) {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+}
+
+// openproxyAnswer is an OpenProxyDB record with the prefix length of the
+// single IP or CIDR range it came from
+type openproxyAnswer struct {
+	record reader.OpenproxyDBRecord
+	bits   int
+}
+
+// sourceCaches holds one range cache per source looked up for every merged
+// network. Every worker context owns its own set, since the caches are not
+// safe for concurrent use.
+type sourceCaches struct {
+	ipinfoLite    rangeCache[reader.IPinfoLiteRecord]
+	geoLiteASN    rangeCache[reader.GeoLite2ASNRecord]
+	routeViewsASN rangeCache[reader.RouteViewsASNRecord]
+	rib           rangeCache[reader.RIBRecord]
+	geoWhois      rangeCache[reader.GeoWhoisCountryRecord]
+	qqwry         rangeCache[reader.QQWryRecord]
+	openproxyDB   rangeCache[openproxyAnswer]
+}
+
+// mmdbNetwork converts the result of a MaxMind DB network lookup to a cache
+// load result
+func mmdbNetwork(network *net.IPNet, found bool, err error) (netip.Prefix, bool) {
+	if err != nil || network == nil {
+		return netip.Prefix{}, false
+	}
+	prefix, ok := netipx.FromStdIPNet(network)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	return prefix, found
+}
+
+func (c *sourceCaches) lookupIPinfoLite(r *reader.IPinfoLiteReader, ip net.IP) *cacheEntry[reader.IPinfoLiteRecord] {
+	return c.ipinfoLite.lookup(ip, func(ip net.IP, rec *reader.IPinfoLiteRecord) (netip.Prefix, bool) {
+		return mmdbNetwork(r.LookupNetworkTo(ip, rec))
+	})
+}
+
+func (c *sourceCaches) lookupGeoLiteASN(r *reader.GeoLite2ASNReader, ip net.IP) *cacheEntry[reader.GeoLite2ASNRecord] {
+	return c.geoLiteASN.lookup(ip, func(ip net.IP, rec *reader.GeoLite2ASNRecord) (netip.Prefix, bool) {
+		return mmdbNetwork(r.LookupNetworkTo(ip, rec))
+	})
+}
+
+func (c *sourceCaches) lookupRouteViewsASN(r *reader.RouteViewsASNReader, ip net.IP) *cacheEntry[reader.RouteViewsASNRecord] {
+	return c.routeViewsASN.lookup(ip, func(ip net.IP, rec *reader.RouteViewsASNRecord) (netip.Prefix, bool) {
+		return mmdbNetwork(r.LookupNetworkTo(ip, rec))
+	})
+}
+
+func (c *sourceCaches) lookupRIB(r *reader.RIBReader, ip net.IP) *cacheEntry[reader.RIBRecord] {
+	return c.rib.lookup(ip, r.LookupNetworkTo)
+}
+
+func (c *sourceCaches) lookupGeoWhois(r *reader.GeoWhoisCountryReader, ip net.IP) *cacheEntry[reader.GeoWhoisCountryRecord] {
+	return c.geoWhois.lookup(ip, func(ip net.IP, rec *reader.GeoWhoisCountryRecord) (netip.Prefix, bool) {
+		return mmdbNetwork(r.LookupNetworkTo(ip, rec))
+	})
+}
+
+func (c *sourceCaches) lookupQQWry(r *reader.QQWryReader, ip net.IP) *cacheEntry[reader.QQWryRecord] {
+	return c.qqwry.lookup(ip, func(ip net.IP, rec *reader.QQWryRecord) (netip.Prefix, bool) {
+		network, err := r.LookupNetworkTo(ip, rec)
+		return network, err == nil
+	})
+}
+
+func (c *sourceCaches) lookupOpenproxyDB(r *reader.OpenproxyDBReader, ip net.IP) *cacheEntry[openproxyAnswer] {
+	// The cached network leaves single IPs out, so one inside it must not
+	// be answered from the cache
+	if r.IsSingleIP(ip) {
+		c.openproxyDB.entry.network = netip.Prefix{}
+	}
+	return c.openproxyDB.lookup(ip, func(ip net.IP, answer *openproxyAnswer) (netip.Prefix, bool) {
+		network, bits, found := r.LookupRangeTo(ip, &answer.record)
+		answer.bits = bits
+		return network, found
+	})
+}
+
+// addStats adds the hit and miss counts of every cache to s
+func (c *sourceCaches) addStats(s *Stats) {
+	s.IPinfoLiteCache.add(c.ipinfoLite.stats())
+	s.GeoLiteASNCache.add(c.geoLiteASN.stats())
+	s.RouteViewsASNCache.add(c.routeViewsASN.stats())
+	s.RIBCache.add(c.rib.stats())
+	s.GeoWhoisCache.add(c.geoWhois.stats())
+	s.QQWryCache.add(c.qqwry.stats())
+	s.OpenProxyDBCache.add(c.openproxyDB.stats())
+}
@@ -0,0 +1,100 @@
+package merger
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"merged-ip-data/internal/reader"
+)
+
+// addressOrderIPs returns the first address of n consecutive /28s starting
+// at 10.0.0.0, the order merged networks are looked up in
+func addressOrderIPs(n int) []net.IP {
+	ips := make([]net.IP, n)
+	addr := netip.MustParseAddr("10.0.0.0")
+	for i := range ips {
+		ips[i] = addr.AsSlice()
+		for j := 0; j < 16; j++ {
+			addr = addr.Next()
+		}
+	}
+	return ips
+}
+
+// loadSlash20 answers every lookup from the /20 holding ip, as a source with
+// /20 networks would
+func loadSlash20(ip net.IP, value *int) (netip.Prefix, bool) {
+	addr, _ := netip.AddrFromSlice(ip)
+	*value++
+	return netip.PrefixFrom(addr.Unmap(), 20).Masked(), true
+}
+
+func TestRangeCacheReusesNetwork(t *testing.T) {
+	var cache rangeCache[int]
+	for _, ip := range addressOrderIPs(512) {
+		if entry := cache.lookup(ip, loadSlash20); !entry.found {
+			t.Fatalf("%s: not found", ip)
+		}
+	}
+
+	// 512 /28s span two /20s, so only two lookups reach the source
+	if got := cache.stats(); got.Misses != 2 || got.Hits != 510 {
+		t.Errorf("stats = %+v, want 510 hits and 2 misses", got)
+	}
+}
+
+func TestRangeCacheInvalidPrefix(t *testing.T) {
+	var cache rangeCache[int]
+	load := func(ip net.IP, value *int) (netip.Prefix, bool) {
+		*value = 1
+		return netip.Prefix{}, true
+	}
+	for _, ip := range addressOrderIPs(4) {
+		cache.lookup(ip, load)
+	}
+	if got := cache.stats(); got.Misses != 4 {
+		t.Errorf("misses = %d, want 4: an invalid prefix must not be reused", got.Misses)
+	}
+}
+
+func BenchmarkRangeCacheLookup(b *testing.B) {
+	ips := addressOrderIPs(4096)
+	var cache rangeCache[int]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.lookup(ips[i%len(ips)], loadSlash20)
+	}
+}
+
+// BenchmarkMergedRecordToMMDBType measures the conversion done for every
+// inserted network, with the sections a typical merged record carries
+func BenchmarkMergedRecordToMMDBType(b *testing.B) {
+	names := map[string]string{"en": "Frankfurt am Main", "de": "Frankfurt am Main"}
+	record := MergedRecord{
+		City:      CityRecord{GeonameID: 2925533, Names: names},
+		Continent: ContinentRecord{Code: "EU", GeonameID: 6255148, Names: map[string]string{"en": "Europe"}},
+		Country:   CountryRecord{GeonameID: 2921044, ISOCode: "DE", Names: map[string]string{"en": "Germany"}},
+		Location: LocationRecord{
+			AccuracyRadius: 20,
+			Latitude:       50.1188,
+			Longitude:      8.6843,
+			TimeZone:       "Europe/Berlin",
+			HasCoordinates: true,
+		},
+		Postal:       PostalRecord{Code: "60313"},
+		Subdivisions: []SubdivisionRecord{{GeonameID: 2905330, ISOCode: "HE", Names: map[string]string{"en": "Hesse"}}},
+		ASN:          ASNRecord{Number: 64496, Organization: "Example Hosting GmbH", Domain: "example.net"},
+		Proxy: ProxyRecord{
+			IsProxy:   true,
+			IsHosting: true,
+			Sources:   reader.SourceOpenProxyDB,
+		},
+		Connection: ConnectionRecord{ConnectionType: reader.ConnectionHosting},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		record.ToMMDBType(nil)
+	}
+}
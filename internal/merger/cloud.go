@@ -0,0 +1,96 @@
+package merger
+
+import (
+	"fmt"
+	"net/netip"
+
+	"merged-ip-data/internal/reader"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// applyCloudRange records the cloud provider owning the network and marks it
+// as hosting, alongside whatever proxy flags it already has
+func (r *MergedRecord) applyCloudRange(cloud *reader.CloudRangeRecord) {
+	r.Hosting = HostingRecord{
+		Provider: cloud.Provider,
+		Service:  cloud.Service,
+		Region:   cloud.Region,
+	}
+	r.Proxy.IsHosting = true
+	r.Proxy.Sources |= reader.SourceCloud
+}
+
+// clearAllowlisted clears the allowlisted flags from the proxy record, and
+// the hosting section when hosting is among them. Reports whether anything
+// was cleared.
+func (r *MergedRecord) clearAllowlisted(flags reader.ProxyFlag) bool {
+	cleared := r.Proxy.clearFlags(flags)
+	if flags&reader.FlagHosting != 0 && r.Hosting != (HostingRecord{}) {
+		r.Hosting = HostingRecord{}
+		cleared = true
+	}
+	return cleared
+}
+
+// processCloudRanges inserts every published cloud provider range directly
+// into the tree, for the same reason processProxyCIDRs does: a provider
+// range is usually much more specific than the geo network containing it.
+// Ranges are visited least specific first, so a nested range's hosting
+// section replaces its parent's. Parts of a range allowlisted for hosting
+// get no hosting section, as they get no hosting flag; insertProxyPrefix
+// counts the suppression. A range counts as inserted once its hosting flag
+// reached the tree.
+func (m *Merger) processCloudRanges() {
+	inserted := 0
+	skipped := 0
+
+	m.cloudRanges.ForEach(func(prefix netip.Prefix, cloud reader.CloudRangeRecord) {
+		hosting := HostingRecord{Provider: cloud.Provider, Service: cloud.Service, Region: cloud.Region}
+		hostingMMDB := hosting.toMMDBType()
+		for _, piece := range m.allowlist.Split(prefix) {
+			if piece.Flags&reader.FlagHosting != 0 {
+				continue
+			}
+			if err := m.insertHostingNetwork(piece.Prefix, hostingMMDB); err != nil {
+				if !m.skipInsertError(err) {
+					fmt.Printf("Warning: failed to insert cloud range %s: %v\n", piece.Prefix, err)
+				}
+				continue
+			}
+		}
+
+		if m.insertProxyPrefix(prefix, ProxyRecord{IsHosting: true, Sources: reader.SourceCloud}) {
+			inserted++
+		} else {
+			skipped++
+		}
+	})
+
+	fmt.Printf("Cloud provider ranges: %d inserted, %d skipped\n", inserted, skipped)
+	m.stats.CloudRangesInserted = int64(inserted)
+}
+
+// insertHostingNetwork sets the hosting section, and a hosting connection
+// type, of every record covered by prefix, creating a hosting-only record
+// where the tree is empty. Records whose ASN is allowlisted for hosting are
+// left alone, and counted as suppressed by insertProxyNetwork instead; the
+// prefix entries are expected to have been applied by the caller.
+func (m *Merger) insertHostingNetwork(prefix netip.Prefix, hostingMMDB mmdbtype.Map) error {
+	checkASN := m.allowlist.HasASNs()
+	return m.tree.InsertFunc(prefixToIPNet(prefix), func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+		existingMap, ok := existing.(mmdbtype.Map)
+		if !ok {
+			return mmdbtype.Map{keyHosting: hostingMMDB, keyConnection: hostingConnectionMMDB}, nil
+		}
+		if checkASN && m.allowlist.ASNFlags(asnNumberFromMMDB(existingMap))&reader.FlagHosting != 0 {
+			return existing, nil
+		}
+
+		// Never mutate existing: mmdbwriter shares values between leaves
+		copied := existingMap.Copy().(mmdbtype.Map)
+		copied[keyHosting] = hostingMMDB
+		copied[keyConnection] = hostingConnectionMMDB
+		return copied, nil
+	})
+}
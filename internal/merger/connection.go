@@ -0,0 +1,26 @@
+package merger
+
+import (
+	"merged-ip-data/internal/reader"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// hostingConnectionMMDB is the connection section of every cloud range
+var hostingConnectionMMDB = mmdbtype.Map{keyConnectionType: mmdbtype.String(reader.ConnectionHosting)}
+
+// applyConnectionType settles the connection type once ASN, QQWry and proxy
+// enrichment are done. A published cloud range is the strongest signal,
+// followed by the supplied connection ASN list, then any other hosting flag;
+// otherwise the type derived from the QQWry ISP string, if any, is kept.
+// Returns true if the record ends up with a connection type.
+func (r *MergedRecord) applyConnectionType(asns *reader.ConnectionASNs) bool {
+	if r.Hosting.Provider != "" {
+		r.Connection.ConnectionType = reader.ConnectionHosting
+	} else if connType := asns.Lookup(r.ASN.Number); connType != "" {
+		r.Connection.ConnectionType = connType
+	} else if r.Proxy.IsHosting {
+		r.Connection.ConnectionType = reader.ConnectionHosting
+	}
+	return r.Connection.ConnectionType != ""
+}
@@ -1,11 +1,13 @@
 package merger
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"net/netip"
 	"runtime"
+	"slices"
 	"time"
 
 	"merged-ip-data/internal/config"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/maxmind/mmdbwriter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
 )
 
 // logMemStats logs current memory statistics for profiling
@@ -51,45 +54,78 @@ type Merger struct {
 	ipinfoLite      *reader.IPinfoLiteReader
 	dbipCity        *reader.DBIPCityReader
 	routeViewsASN   *reader.RouteViewsASNReader
+	rib             *reader.RIBReader
 	geoWhoisCountry *reader.GeoWhoisCountryReader
+	rirDelegations  *reader.RIRDelegations
 	qqwry           *reader.QQWryReader
 	openproxyDB     *reader.OpenproxyDBReader
+	cloudRanges     *reader.CloudRangesReader
 	badASN          *reader.BadASNReader
+	overrides       *reader.Overrides
+	allowlist       *reader.Allowlist
+	connectionASNs  *reader.ConnectionASNs
+	asnNames        *reader.ASNNames
+
+	// proxySources names the proxy source bits, feeds included
+	proxySources *reader.ProxySources
 
 	tree *mmdbwriter.Tree
 
 	stats Stats
 
-	// Reusable records for lookups to reduce allocations during merge
-	reusableIPinfoRecord      reader.IPinfoLiteRecord
-	reusableGeoLiteASNRecord  reader.GeoLite2ASNRecord
-	reusableRouteViewsRecord  reader.RouteViewsASNRecord
-	reusableGeoWhoisRecord    reader.GeoWhoisCountryRecord
-	reusableQQWryRecord       reader.QQWryRecord
-	reusableGeoLiteCityRecord reader.GeoLite2CityRecord
-	reusableOpenproxyDBRecord reader.OpenproxyDBRecord
-
-	// ASN lookup cache to avoid redundant lookups for adjacent networks
-	cachedASN        ASNRecord
-	cachedASNNetwork *net.IPNet
-	cachedASNValid   bool
+	// asnDisagreements collects the networks the ASN sources disagree on,
+	// in ASN disagreement mode
+	asnDisagreements []asnDisagreement
+
+	// asnSummaries describes every ASN seen in the merged records, for the
+	// ASN companion database
+	asnSummaries asnSummaries
+
+	// countryLocales holds the country and continent data seen per country,
+	// for geo overrides that move a prefix to another country
+	countryLocales countryLocales
 }
 
 // Stats holds merge statistics
 type Stats struct {
-	TotalNetworks          int64
-	GeoLiteCityHits        int64
-	GeoLiteASNHits         int64
-	IPinfoLiteHits         int64
-	DBIPHits               int64
-	RouteViewsASNHits      int64
-	GeoWhoisCountryHits    int64
-	QQWryHits              int64
-	OpenproxyDBHits        int64
-	BadASNHits             int64
-	EmptyRecords           int64
-	ProcessedNetworks      int64
-	SingleProxyIPsInserted int64
+	TotalNetworks               int64
+	GeoLiteCityHits             int64
+	GeoLiteASNHits              int64
+	IPinfoLiteHits              int64
+	DBIPHits                    int64
+	RouteViewsASNHits           int64
+	RIBASNHits                  int64
+	GeoWhoisCountryHits         int64
+	RIRHits                     int64
+	RIRCountryFills             int64
+	QQWryHits                   int64
+	OpenproxyDBHits             int64
+	BadASNHits                  int64
+	CloudRangeHits              int64
+	EmptyRecords                int64
+	ProcessedNetworks           int64
+	SingleProxyIPsInserted      int64
+	SingleProxyPrefixesInserted int64
+	ProxyCIDRsInserted          int64
+	FeedPrefixesInserted        int64
+	CloudRangesInserted         int64
+	SpecialNetworksInserted     int64
+	UnallocatedPrefixesInserted int64
+	ReservedNetworkSkips        int64
+	OverridesApplied            int64
+	AllowlistSuppressions       int64
+	ConnectionTypeHits          int64
+	ASNNamesFilled              int64
+	ASNDisagreements            int64
+
+	// Lookups answered by the per-source range caches
+	IPinfoLiteCache    CacheStats
+	GeoLiteASNCache    CacheStats
+	RouteViewsASNCache CacheStats
+	RIBCache           CacheStats
+	GeoWhoisCache      CacheStats
+	QQWryCache         CacheStats
+	OpenProxyDBCache   CacheStats
 }
 
 // New creates a new Merger instance
@@ -134,6 +170,20 @@ func New() (*Merger, error) {
 	}
 	closers = append(closers, routeViewsASN)
 
+	rib, err := reader.OpenRIB(config.RIBFiles)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to open RIB files: %w", err)
+	}
+	closers = append(closers, rib)
+	if len(config.RIBFiles) > 0 {
+		prefixes, moas := rib.Count()
+		fmt.Printf("RIB origins loaded: %d prefixes (%d MOAS) from %d files\n", prefixes, moas, len(config.RIBFiles))
+		if malformed := rib.Malformed(); malformed > 0 {
+			fmt.Printf("Warning: %d malformed pfx2as lines skipped\n", malformed)
+		}
+	}
+
 	geoWhoisCountry, err := reader.OpenGeoWhoisCountry()
 	if err != nil {
 		cleanup()
@@ -141,12 +191,21 @@ func New() (*Merger, error) {
 	}
 	closers = append(closers, geoWhoisCountry)
 
+	rirDelegations, err := reader.OpenRIRDelegations()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to open RIR delegations: %w", err)
+	}
+	closers = append(closers, rirDelegations)
+	fmt.Printf("RIR delegations loaded: %d ranges %v\n", rirDelegations.Count(), rirDelegations.RegistryCounts())
+
 	qqwry, err := reader.OpenQQWry()
 	if err != nil {
 		cleanup()
 		return nil, fmt.Errorf("failed to open QQWry: %w", err)
 	}
 	closers = append(closers, qqwry)
+	fmt.Printf("QQWry loaded: IPv4 %v, IPv6 %v\n", qqwry.IsIPv4Supported(), qqwry.IsIPv6Supported())
 
 	openproxyDB, err := reader.OpenOpenproxyDB()
 	if err != nil {
@@ -155,24 +214,48 @@ func New() (*Merger, error) {
 	}
 	closers = append(closers, openproxyDB)
 
+	cloudRanges, err := reader.OpenCloudRanges()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to open cloud provider ranges: %w", err)
+	}
+	closers = append(closers, cloudRanges)
+	fmt.Printf("Cloud provider ranges loaded: %d ranges %v\n", cloudRanges.Count(), cloudRanges.ProviderCounts())
+
 	badASN, err := reader.OpenBadASNList(config.BadASNListFile)
 	if err != nil {
 		cleanup()
 		return nil, fmt.Errorf("failed to open bad ASN list: %w", err)
 	}
 	closers = append(closers, badASN)
-	fmt.Printf("Bad ASN list loaded: %d ASNs (includes %d manual entries)\n",
-		badASN.Count(), len(reader.ManuallyAddedBadASNs))
 
-	singleIPs, cidrRanges := openproxyDB.Stats()
-	fmt.Printf("OpenProxyDB loaded: %d single IPs, %d CIDR ranges\n", singleIPs, cidrRanges)
+	overrides, err := reader.LoadOverrides()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to load overrides: %w", err)
+	}
+	overrides.ApplyToBadASN(badASN)
+	fmt.Printf("Local overrides loaded: %d entries\n", overrides.Count())
+
+	fmt.Printf("Bad ASN list loaded: %d ASNs (includes %d manual entries, %d added and %d removed by overrides)\n",
+		badASN.Count(), len(reader.ManuallyAddedBadASNs), len(overrides.BadASNAdd), len(overrides.BadASNRemove))
 
-	badIPCount, err := openproxyDB.LoadBadIPList(config.BadIPListFile)
+	asnNames, err := reader.BuildASNNames(ipinfoLite, geoLiteASN, routeViewsASN)
 	if err != nil {
 		cleanup()
-		return nil, fmt.Errorf("failed to load BadIPList: %w", err)
+		return nil, fmt.Errorf("failed to build ASN names: %w", err)
 	}
-	fmt.Printf("BadIPList loaded: %d IPs merged into proxy data\n", badIPCount)
+	fmt.Printf("ASN names collected: %d ASNs\n", asnNames.Count())
+
+	connectionASNs, err := reader.LoadConnectionASNs(config.ConnectionASNsFile)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to load connection ASNs: %w", err)
+	}
+	fmt.Printf("Connection ASN list loaded: %d ASNs\n", connectionASNs.Count())
+
+	singleIPs, cidrRanges := openproxyDB.Stats()
+	fmt.Printf("OpenProxyDB loaded: %d single IPs, %d CIDR ranges\n", singleIPs, cidrRanges)
 
 	torCount, err := openproxyDB.LoadTorRelays(config.TorRelaysFile)
 	if err != nil {
@@ -181,13 +264,21 @@ func New() (*Merger, error) {
 	}
 	fmt.Printf("Tor relays loaded: %d unique IPs merged into proxy data\n", torCount)
 
-	anycastCount, err := openproxyDB.LoadAnycastPrefixes(config.AnycastV4File, config.AnycastV6File)
+	proxySources, err := openproxyDB.LoadFeeds(config.AllFeeds(), func(feed config.FeedSource, feedStats reader.FeedStats) {
+		fmt.Printf("Feed %s (%s) loaded: %d IPs, %d prefixes, %d expired entries dropped, %d prefixes rejected\n",
+			feed.Name, feed.Path, feedStats.SingleIPs, feedStats.Prefixes, feedStats.Expired, feedStats.Rejected)
+	})
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to load feed: %w", err)
+	}
+
+	feedPrefixCount, err := openproxyDB.BuildFeedOverlays()
 	if err != nil {
 		cleanup()
-		return nil, fmt.Errorf("failed to load anycast prefixes: %w", err)
+		return nil, fmt.Errorf("failed to build feed overlays: %w", err)
 	}
-	fmt.Printf("Anycast prefixes loaded: %d entries (%d in lookup set) — CDN overlay active\n",
-		anycastCount, openproxyDB.AnycastPrefixCount())
+	fmt.Printf("Feed overlays built: %d prefixes in lookup sets\n", feedPrefixCount)
 
 	singleIPs, cidrRanges = openproxyDB.Stats()
 	fmt.Printf("OpenProxyDB total after merge: %d single IPs, %d CIDR ranges\n", singleIPs, cidrRanges)
@@ -198,7 +289,7 @@ func New() (*Merger, error) {
 		Languages:               config.SupportedLanguages,
 		IPVersion:               6,
 		RecordSize:              28,
-		IncludeReservedNetworks: false,
+		IncludeReservedNetworks: config.IncludeReservedNetworks,
 		DisableIPv4Aliasing:     false,
 	})
 	if err != nil {
@@ -206,18 +297,33 @@ func New() (*Merger, error) {
 		return nil, fmt.Errorf("failed to create mmdb tree: %w", err)
 	}
 
-	return &Merger{
+	m := &Merger{
 		geoLiteCity:     geoLiteCity,
 		geoLiteASN:      geoLiteASN,
 		ipinfoLite:      ipinfoLite,
 		dbipCity:        dbipCity,
 		routeViewsASN:   routeViewsASN,
+		rib:             rib,
 		geoWhoisCountry: geoWhoisCountry,
+		rirDelegations:  rirDelegations,
 		qqwry:           qqwry,
 		openproxyDB:     openproxyDB,
+		cloudRanges:     cloudRanges,
 		badASN:          badASN,
+		overrides:       overrides,
+		allowlist:       overrides.Allowlist,
+		connectionASNs:  connectionASNs,
+		asnNames:        asnNames,
+		proxySources:    proxySources,
 		tree:            tree,
-	}, nil
+		asnSummaries:    make(asnSummaries),
+		countryLocales:  make(countryLocales),
+	}
+
+	resolved := openproxyDB.AnnotateFeedOperators(config.AnycastFeedName, m.newWorkerContext().anycastOperator)
+	fmt.Printf("Anycast operators resolved: %d prefixes\n", resolved)
+
+	return m, nil
 }
 
 // Close closes all database readers
@@ -264,6 +370,21 @@ func (m *Merger) Close() error {
 			errs = append(errs, err)
 		}
 	}
+	if m.rirDelegations != nil {
+		if err := m.rirDelegations.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.rib != nil {
+		if err := m.rib.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.cloudRanges != nil {
+		if err := m.cloudRanges.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if m.badASN != nil {
 		if err := m.badASN.Close(); err != nil {
 			errs = append(errs, err)
@@ -293,18 +414,45 @@ func (m *Merger) Merge() error {
 	runtime.GC()
 	logMemStats("After GC (Phase 1)")
 
-	fmt.Println("Processing DB-IP networks (supplementary data)...")
-	if err := m.processDBIPNetworks(); err != nil {
+	fmt.Printf("Processing DB-IP networks (supplementary data) with %d workers...\n", numWorkers)
+	if err := m.processDBIPNetworks(numWorkers); err != nil {
 		return fmt.Errorf("failed to process DB-IP: %w", err)
 	}
 	logMemStats("After DB-IP")
 
-	fmt.Println("Processing single proxy IPs (direct /32 and /128 insertion)...")
-	if err := m.processSingleProxyIPs(); err != nil {
+	fmt.Println("Processing proxy CIDR ranges and feed prefixes...")
+	if err := m.processProxyCIDRs(); err != nil {
+		return fmt.Errorf("failed to process proxy CIDR ranges: %w", err)
+	}
+	logMemStats("After Proxy CIDRs")
+
+	fmt.Println("Processing cloud provider IP ranges...")
+	m.processCloudRanges()
+	logMemStats("After Cloud Ranges")
+
+	fmt.Println("Processing single proxy IPs (coalesced prefix insertion)...")
+	if err := m.processSingleProxyIPs(numWorkers); err != nil {
 		return fmt.Errorf("failed to process single proxy IPs: %w", err)
 	}
 	logMemStats("After Single Proxy IPs")
 
+	if config.IncludeReservedNetworks {
+		fmt.Println("Processing special-purpose and unallocated networks...")
+		if err := m.processReservedNetworks(); err != nil {
+			return fmt.Errorf("failed to process reserved networks: %w", err)
+		}
+	}
+
+	fmt.Println("Applying local overrides...")
+	m.processOverrides()
+
+	if config.ASNReportFile != "" {
+		if err := writeASNReport(config.ASNReportFile, m.asnDisagreements); err != nil {
+			return err
+		}
+		fmt.Printf("ASN disagreement report written to %s: %d networks\n", config.ASNReportFile, len(m.asnDisagreements))
+	}
+
 	// Final GC before write phase
 	runtime.GC()
 	logMemStats("After GC (Phase 3)")
@@ -319,563 +467,486 @@ func (m *Merger) Merge() error {
 	return nil
 }
 
-// processGeoLiteCityNetworks iterates through GeoLite2-City and merges with other sources
-func (m *Merger) processGeoLiteCityNetworks() error {
-	networks := m.geoLiteCity.Networks()
-
-	// Reuse a single record to reduce allocations
-	var record MergedRecord
-
-	for networks.Next() {
-		var geoRecord reader.GeoLite2CityRecord
-		network, err := networks.Network(&geoRecord)
-		if err != nil {
-			fmt.Printf("Warning: failed to read network: %v\n", err)
-			continue
-		}
-
-		m.stats.TotalNetworks++
-
-		record.Reset()
-		m.buildMergedRecord(network, &geoRecord, &record)
-
-		if record.IsEmpty() {
-			m.stats.EmptyRecords++
-			continue
-		}
-
-		if err := m.tree.Insert(network, record.ToMMDBType()); err != nil {
-			fmt.Printf("Warning: failed to insert network %s: %v\n", network, err)
-			continue
-		}
-
-		m.stats.ProcessedNetworks++
+// newWorkerPool creates a worker pool sharing the Merger's readers
+func (m *Merger) newWorkerPool(numWorkers int) *workerPool {
+	return newWorkerPool(numWorkers, m.newWorkerContext)
+}
 
-		if m.stats.ProcessedNetworks%100000 == 0 {
-			fmt.Printf("  Processed %d networks...\n", m.stats.ProcessedNetworks)
-		}
+// newWorkerContext creates a worker context sharing the Merger's readers,
+// with its own reusable records, caches and statistics
+func (m *Merger) newWorkerContext() *workerContext {
+	return &workerContext{
+		ipinfoLite:      m.ipinfoLite,
+		geoLiteASN:      m.geoLiteASN,
+		routeViewsASN:   m.routeViewsASN,
+		rib:             m.rib,
+		geoWhoisCountry: m.geoWhoisCountry,
+		rirDelegations:  m.rirDelegations,
+		qqwry:           m.qqwry,
+		openproxyDB:     m.openproxyDB,
+		cloudRanges:     m.cloudRanges,
+		badASN:          m.badASN,
+		overrides:       m.overrides,
+		allowlist:       m.allowlist,
+		connectionASNs:  m.connectionASNs,
+		asnNames:        m.asnNames,
+		proxySources:    m.proxySources,
+		asnSummaries:    make(asnSummaries),
 	}
+}
 
-	return networks.Err()
+// addWorkerStats adds the statistics, ASN disagreements and ASN summaries
+// gathered by the workers of pool. Only valid once all workers are done.
+func (m *Merger) addWorkerStats(pool *workerPool) {
+	workerStats := pool.aggregateStats()
+	m.stats.TotalNetworks += workerStats.TotalNetworks
+	m.stats.GeoLiteCityHits += workerStats.GeoLiteCityHits
+	m.stats.GeoLiteASNHits += workerStats.GeoLiteASNHits
+	m.stats.IPinfoLiteHits += workerStats.IPinfoLiteHits
+	m.stats.RouteViewsASNHits += workerStats.RouteViewsASNHits
+	m.stats.RIBASNHits += workerStats.RIBASNHits
+	m.stats.GeoWhoisCountryHits += workerStats.GeoWhoisCountryHits
+	m.stats.RIRHits += workerStats.RIRHits
+	m.stats.RIRCountryFills += workerStats.RIRCountryFills
+	m.stats.QQWryHits += workerStats.QQWryHits
+	m.stats.OpenproxyDBHits += workerStats.OpenproxyDBHits
+	m.stats.BadASNHits += workerStats.BadASNHits
+	m.stats.CloudRangeHits += workerStats.CloudRangeHits
+	m.stats.AllowlistSuppressions += workerStats.AllowlistSuppressions
+	m.stats.ConnectionTypeHits += workerStats.ConnectionTypeHits
+	m.stats.ASNNamesFilled += workerStats.ASNNamesFilled
+	m.stats.ASNDisagreements += workerStats.ASNDisagreements
+	m.stats.EmptyRecords += workerStats.EmptyRecords
+	m.stats.IPinfoLiteCache.add(workerStats.IPinfoLiteCache)
+	m.stats.GeoLiteASNCache.add(workerStats.GeoLiteASNCache)
+	m.stats.RouteViewsASNCache.add(workerStats.RouteViewsASNCache)
+	m.stats.RIBCache.add(workerStats.RIBCache)
+	m.stats.GeoWhoisCache.add(workerStats.GeoWhoisCache)
+	m.stats.QQWryCache.add(workerStats.QQWryCache)
+	m.stats.OpenProxyDBCache.add(workerStats.OpenProxyDBCache)
+	m.asnDisagreements = append(m.asnDisagreements, pool.asnDisagreements()...)
+	pool.mergeASNSummaries(m.asnSummaries)
 }
 
 // processGeoLiteCityNetworksParallel processes GeoLite2-City networks using parallel workers.
 // This significantly speeds up processing on multi-core systems by:
-// 1. Reading networks from GeoLite2-City sequentially (iterator is not thread-safe)
-// 2. Processing enrichment (ASN, QQWry, etc.) in parallel via worker pool
-// 3. Inserting results into the tree sequentially (tree is not thread-safe)
+// 1. Splitting the address space into shards (see shardPrefixes)
+// 2. Reading and enriching (ASN, QQWry, etc.) whole shards in parallel via the worker pool
+// 3. Inserting the results into the tree sequentially in address order (tree is not thread-safe)
 func (m *Merger) processGeoLiteCityNetworksParallel(numWorkers int) error {
-	// Create worker pool
-	pool := newWorkerPool(
-		numWorkers,
-		m.ipinfoLite,
-		m.geoLiteASN,
-		m.routeViewsASN,
-		m.geoWhoisCountry,
-		m.qqwry,
-		m.openproxyDB,
-		m.badASN,
-	)
-
-	// Start workers
-	pool.start()
-
-	// Start result consumer in a separate goroutine
-	var insertErr error
-	var insertedCount int64
-	insertDone := make(chan struct{})
+	pool := m.newWorkerPool(numWorkers)
+
+	// Each worker iterates its own shard; iterators over one reader are
+	// independent, so this is safe
+	read := func(shard netip.Prefix, process func(workItem)) error {
+		for _, part := range shardParts(shard) {
+			networks := m.geoLiteCity.NetworksWithin(prefixToIPNet(part))
+			for networks.Next() {
+				var geoRecord reader.GeoLite2CityRecord
+				network, err := networks.Network(&geoRecord)
+				if err != nil {
+					fmt.Printf("Warning: failed to read network: %v\n", err)
+					continue
+				}
+				if !inShard(shard, network) {
+					continue
+				}
 
-	go func() {
-		defer close(insertDone)
-		for result := range pool.results() {
-			if err := m.tree.Insert(result.network, result.mmdbRecord); err != nil {
-				fmt.Printf("Warning: failed to insert network %s: %v\n", result.network, err)
-				continue
+				process(workItem{
+					network:   network,
+					geoRecord: geoRecord,
+				})
 			}
-			insertedCount++
-			if insertedCount%100000 == 0 {
-				fmt.Printf("  Inserted %d networks...\n", insertedCount)
+			if err := networks.Err(); err != nil {
+				return err
 			}
 		}
-	}()
+		return nil
+	}
 
-	// Read networks and submit to worker pool
-	networks := m.geoLiteCity.Networks()
-	for networks.Next() {
-		var geoRecord reader.GeoLite2CityRecord
-		network, err := networks.Network(&geoRecord)
-		if err != nil {
-			fmt.Printf("Warning: failed to read network: %v\n", err)
-			continue
+	var insertedCount int64
+	insert := func(result resultItem) {
+		if err := m.tree.Insert(result.network, result.mmdbRecord); err != nil {
+			fmt.Printf("Warning: failed to insert network %s: %v\n", result.network, err)
+			return
+		}
+		insertedCount++
+		m.countryLocales.observe(result.mmdbRecord)
+		if insertedCount%100000 == 0 {
+			fmt.Printf("  Inserted %d networks...\n", insertedCount)
 		}
-
-		pool.submit(workItem{
-			network:   network,
-			geoRecord: geoRecord,
-		})
 	}
 
-	// Signal no more work
-	pool.closeWork()
-
-	// Wait for all workers to finish
-	pool.wait()
-
-	// Wait for all insertions to complete
-	<-insertDone
-
-	// Check for iterator errors
-	if err := networks.Err(); err != nil {
+	shards := shardPrefixes(m.geoLiteCity.Metadata().IPVersion == 6)
+	if err := pool.run(shards, read, insert); err != nil {
 		return err
 	}
 
-	if insertErr != nil {
-		return insertErr
-	}
-
-	// Aggregate statistics from workers
-	workerStats := pool.aggregateStats()
-	m.stats.TotalNetworks = workerStats.TotalNetworks
-	m.stats.GeoLiteCityHits = workerStats.GeoLiteCityHits
-	m.stats.GeoLiteASNHits = workerStats.GeoLiteASNHits
-	m.stats.IPinfoLiteHits = workerStats.IPinfoLiteHits
-	m.stats.RouteViewsASNHits = workerStats.RouteViewsASNHits
-	m.stats.GeoWhoisCountryHits = workerStats.GeoWhoisCountryHits
-	m.stats.QQWryHits = workerStats.QQWryHits
-	m.stats.OpenproxyDBHits = workerStats.OpenproxyDBHits
-	m.stats.BadASNHits = workerStats.BadASNHits
-	m.stats.EmptyRecords = workerStats.EmptyRecords
-	m.stats.ProcessedNetworks = insertedCount
+	m.addWorkerStats(pool)
+	m.stats.ProcessedNetworks += insertedCount
 
 	return nil
 }
 
 // processDBIPNetworks processes DB-IP networks for IPs not covered by GeoLite2
-func (m *Merger) processDBIPNetworks() error {
-	if err := m.processDBIPReader(m.dbipCity.IPv4Reader()); err != nil {
+func (m *Merger) processDBIPNetworks(numWorkers int) error {
+	if err := m.processDBIPReader(m.dbipCity.IPv4Reader(), numWorkers); err != nil {
 		return err
 	}
-	return m.processDBIPReader(m.dbipCity.IPv6Reader())
+	return m.processDBIPReader(m.dbipCity.IPv6Reader(), numWorkers)
 }
 
-func (m *Merger) processDBIPReader(r *reader.Reader) error {
-	networks := r.Networks()
-
-	// Reuse a single record to reduce allocations
-	var record MergedRecord
-
-	for networks.Next() {
-		var dbipRecord reader.DBIPCityRecord
-		network, err := networks.Network(&dbipRecord)
-		if err != nil {
-			fmt.Printf("Warning: failed to read DB-IP network: %v\n", err)
-			continue
-		}
+// processDBIPReader enriches the DB-IP networks of r on the worker pool, shard
+// by shard as in the GeoLite2-City phase, and merges them into the tree.
+// Networks GeoLite2-City has geo data for are skipped by the workers.
+func (m *Merger) processDBIPReader(r *reader.Reader, numWorkers int) error {
+	pool := m.newWorkerPool(numWorkers)
 
-		if !dbipRecord.HasGeoData() {
-			continue
-		}
-
-		ip := network.IP
-
-		// Use reusable record to check if GeoLite2 has data for this IP
-		m.reusableGeoLiteCityRecord.Reset()
-		if err := m.geoLiteCity.LookupTo(ip, &m.reusableGeoLiteCityRecord); err == nil && m.reusableGeoLiteCityRecord.HasGeoData() {
-			continue
-		}
+	read := func(shard netip.Prefix, process func(workItem)) error {
+		// Reused for the GeoLite2 check within this shard
+		var geoRecord reader.GeoLite2CityRecord
 
-		m.stats.TotalNetworks++
+		for _, part := range shardParts(shard) {
+			networks := r.NetworksWithin(prefixToIPNet(part))
+			for networks.Next() {
+				dbipRecord := &reader.DBIPCityRecord{}
+				network, err := networks.Network(dbipRecord)
+				if err != nil {
+					fmt.Printf("Warning: failed to read DB-IP network: %v\n", err)
+					continue
+				}
+				if !inShard(shard, network) || !dbipRecord.HasGeoData() {
+					continue
+				}
 
-		record.Reset()
-		m.buildMergedRecordFromDBIP(network, &dbipRecord, &record)
+				// Skip networks GeoLite2 already has data for
+				geoRecord.Reset()
+				if err := m.geoLiteCity.LookupTo(network.IP, &geoRecord); err == nil && geoRecord.HasGeoData() {
+					continue
+				}
 
-		if record.IsEmpty() {
-			m.stats.EmptyRecords++
-			continue
+				process(workItem{
+					network:    network,
+					dbipRecord: dbipRecord,
+				})
+			}
+			if err := networks.Err(); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		if err := m.insertWithMerge(network, &record); err != nil {
-			// Silently skip reserved and aliased networks - these are expected
-			// when DB-IP data contains IANA special-purpose address ranges
-			var aliasedErr *mmdbwriter.AliasedNetworkError
-			var reservedErr *mmdbwriter.ReservedNetworkError
-			if errors.As(err, &aliasedErr) || errors.As(err, &reservedErr) {
-				continue
+	insert := func(result resultItem) {
+		// GeoLite2 data already in the tree keeps its ASN, so the summary
+		// only counts the parts the DB-IP record supplies the ASN for
+		ipv4, ipv6Blocks := m.sizeWithoutASN(result.network)
+		if err := m.insertWithMerge(result.network, result.mmdbRecord); err != nil {
+			// Reserved and aliased networks are expected when DB-IP data
+			// contains IANA special-purpose address ranges
+			if m.skipInsertError(err) {
+				return
 			}
-			fmt.Printf("Warning: failed to insert DB-IP network %s: %v\n", network, err)
-			continue
+			fmt.Printf("Warning: failed to insert DB-IP network %s: %v\n", result.network, err)
+			return
 		}
 
 		m.stats.DBIPHits++
 		m.stats.ProcessedNetworks++
-	}
-
-	return networks.Err()
-}
-
-// buildMergedRecord creates a merged record for a network using GeoLite2-City as primary.
-// The record parameter should be pre-reset before calling this function.
-func (m *Merger) buildMergedRecord(network *net.IPNet, geoRecord *reader.GeoLite2CityRecord, record *MergedRecord) {
-	if geoRecord.HasGeoData() {
-		m.stats.GeoLiteCityHits++
-
-		// Source maps from maxminddb are read-only, safe to reference directly
-		record.City = CityRecord{
-			GeonameID: geoRecord.City.GeonameID,
-			Names:     geoRecord.City.Names,
-		}
-
-		record.Continent = ContinentRecord{
-			Code:      geoRecord.Continent.Code,
-			GeonameID: geoRecord.Continent.GeonameID,
-			Names:     geoRecord.Continent.Names,
-		}
-
-		record.Country = CountryRecord{
-			GeonameID: geoRecord.Country.GeonameID,
-			ISOCode:   geoRecord.Country.ISOCode,
-			Names:     geoRecord.Country.Names,
-		}
-
-		record.Location = LocationRecord{
-			AccuracyRadius: geoRecord.Location.AccuracyRadius,
-			Latitude:       geoRecord.Location.Latitude,
-			Longitude:      geoRecord.Location.Longitude,
-			MetroCode:      geoRecord.Location.MetroCode,
-			TimeZone:       geoRecord.Location.TimeZone,
-			HasCoordinates: geoRecord.HasLocationData(),
+		if ipv4 != 0 || ipv6Blocks != 0 {
+			m.asnSummaries.observeSize(result.summary, ipv4, ipv6Blocks)
 		}
-
-		record.Postal = PostalRecord{
-			Code: geoRecord.Postal.Code,
-		}
-
-		record.RegisteredCountry = CountryRecord{
-			GeonameID: geoRecord.RegisteredCountry.GeonameID,
-			ISOCode:   geoRecord.RegisteredCountry.ISOCode,
-			Names:     geoRecord.RegisteredCountry.Names,
+		if result.disagreement != nil {
+			m.stats.ASNDisagreements++
+			m.asnDisagreements = append(m.asnDisagreements, *result.disagreement)
 		}
+	}
 
-		if len(geoRecord.Subdivisions) > 0 {
-			record.Subdivisions = make([]SubdivisionRecord, len(geoRecord.Subdivisions))
-			for i, sub := range geoRecord.Subdivisions {
-				record.Subdivisions[i] = SubdivisionRecord{
-					GeonameID: sub.GeonameID,
-					ISOCode:   sub.ISOCode,
-					Names:     sub.Names,
-				}
-			}
-		}
+	shards := shardPrefixes(r.Metadata().IPVersion == 6)
+	if err := pool.run(shards, read, insert); err != nil {
+		return err
 	}
 
-	m.enrichWithASNData(network.IP, record)
-	m.enrichWithCountryFallback(network.IP, record)
-	m.enrichWithQQWryData(network.IP, record)
-	m.enrichWithProxyData(network.IP, record)
+	m.addWorkerStats(pool)
+	return nil
 }
 
-// buildMergedRecordFromDBIP creates a merged record using DB-IP as primary geo source.
-// The record parameter should be pre-reset before calling this function.
-func (m *Merger) buildMergedRecordFromDBIP(network *net.IPNet, dbipRecord *reader.DBIPCityRecord, record *MergedRecord) {
-	if dbipRecord.HasGeoData() {
-		record.City = CityRecord{
-			Names: map[string]string{"en": dbipRecord.City},
-		}
-
-		record.Country = CountryRecord{
-			ISOCode: dbipRecord.CountryCode,
-		}
+// processProxyCIDRs inserts every OpenProxyDB CIDR range and every flag feed
+// prefix (bgp.tools anycast and configured feeds) directly into the tree.
+// Enrichment only checks each geo network's base address, so a /24 VPN range
+// inside a GeoLite2 /20 would otherwise never reach the output. Proxy flags
+// are unioned onto the existing geo/ASN data exactly as processSingleProxyIPs
+// does for single IPs.
+func (m *Merger) processProxyCIDRs() error {
+	inserted := 0
+	skipped := 0
 
-		if dbipRecord.HasLocationData() {
-			record.Location = LocationRecord{
-				Latitude:       float64(dbipRecord.Latitude),
-				Longitude:      float64(dbipRecord.Longitude),
-				TimeZone:       dbipRecord.Timezone,
-				HasCoordinates: true,
-			}
+	insert := func(prefix netip.Prefix, proxy ProxyRecord) {
+		if m.insertProxyPrefix(prefix, proxy) {
+			inserted++
+		} else {
+			skipped++
 		}
+	}
 
-		if dbipRecord.Postcode != "" {
-			record.Postal = PostalRecord{
-				Code: dbipRecord.Postcode,
-			}
-		}
+	m.openproxyDB.ForEachCIDR(func(prefix netip.Prefix, record reader.OpenproxyDBRecord) {
+		insert(prefix, newProxyRecord(&record))
+	})
+	cidrInserted := inserted
 
-		if dbipRecord.State1 != "" {
-			record.Subdivisions = []SubdivisionRecord{
-				{
-					Names: map[string]string{"en": dbipRecord.State1},
-				},
-			}
-		}
-	}
+	m.openproxyDB.ForEachFeedPrefix(func(prefix netip.Prefix, record reader.OpenproxyDBRecord) {
+		insert(prefix, newProxyRecord(&record))
+	})
 
-	m.enrichWithASNData(network.IP, record)
-	m.enrichWithCountryFallback(network.IP, record)
-	m.enrichWithQQWryData(network.IP, record)
-	m.enrichWithProxyData(network.IP, record)
+	fmt.Printf("Proxy ranges: %d CIDR ranges and %d feed prefixes inserted, %d skipped\n",
+		cidrInserted, inserted-cidrInserted, skipped)
+	m.stats.ProxyCIDRsInserted = int64(cidrInserted)
+	m.stats.FeedPrefixesInserted = int64(inserted - cidrInserted)
+	return nil
 }
 
-// enrichWithCountryFallback adds country information from GeoWhois when country is missing
-func (m *Merger) enrichWithCountryFallback(ip net.IP, record *MergedRecord) {
-	if record.Country.ISOCode != "" {
-		return
-	}
+// processSingleProxyIPs directly inserts every single IP from OpenProxyDB and BadIPList
+// into the MMDB tree. This ensures complete proxy coverage for individual IPs that would
+// otherwise be missed when only the network base address is checked during enrichment.
+//
+// Addresses are first grouped by their proxy flags and each group is coalesced
+// into the minimal set of prefixes, so runs of adjacent IPs with identical flags become
+// one insert instead of hundreds of /32 or /128 inserts that each split tree nodes.
+// Each prefix carries the union of its addresses' sources and the widest seen dates.
+// The grouping runs on numWorkers goroutines, one shard of the address space
+// at a time, and each shard's prefixes are inserted in address order. The
+// inserts themselves stay serialized, so they bound how fast this phase runs.
+// Uses InsertFunc to merge proxy flags with any existing geo/ASN data in the tree.
+func (m *Merger) processSingleProxyIPs(numWorkers int) error {
+	singleIPs := m.openproxyDB.SingleIPs()
 
-	m.reusableGeoWhoisRecord.Reset()
-	if err := m.geoWhoisCountry.LookupTo(ip, &m.reusableGeoWhoisRecord); err == nil && m.reusableGeoWhoisRecord.HasCountry() {
-		m.stats.GeoWhoisCountryHits++
-		record.Country.ISOCode = m.reusableGeoWhoisRecord.CountryCode
+	shards := shardPrefixes(true)
+	byShard := make([][]netip.Addr, len(shards))
+	for addr := range singleIPs {
+		i := shardIndex(addr)
+		byShard[i] = append(byShard[i], addr)
 	}
-}
 
-// enrichWithQQWryData adds Chinese location data from QQWry (Chunzhen) database for Chinese IPs.
-// This provides more accurate and detailed Chinese location names (zh-CN) for IPs in China.
-func (m *Merger) enrichWithQQWryData(ip net.IP, record *MergedRecord) {
-	// Only enrich for Chinese IPs
-	if record.Country.ISOCode != "CN" {
-		return
-	}
+	// Counted per shard, as the shards are grouped concurrently
+	suppressions := make([]int64, len(shards))
+	dropped := make([]int, len(shards))
 
-	m.reusableQQWryRecord.Reset()
-	if err := m.qqwry.LookupTo(ip, &m.reusableQQWryRecord); err != nil || !m.reusableQQWryRecord.HasGeoData() {
-		return
+	type proxyPrefix struct {
+		prefix netip.Prefix
+		proxy  ProxyRecord
 	}
 
-	// Verify the record is indeed for China
-	if !m.reusableQQWryRecord.IsChina() {
-		return
+	// proxyIP is one single IP of a group, with its own evidence
+	type proxyIP struct {
+		addr  netip.Addr
+		proxy ProxyRecord
 	}
-
-	m.stats.QQWryHits++
-
-	// Enrich city names with Chinese (zh-CN)
-	if m.reusableQQWryRecord.HasCityData() {
-		if record.City.Names == nil {
-			record.City.Names = make(map[string]string)
-		}
-		record.City.Names["zh-CN"] = m.reusableQQWryRecord.CityName
+	type proxyGroup struct {
+		builder netipx.IPSetBuilder
+		members []proxyIP
 	}
 
-	// Enrich subdivision (province) names with Chinese (zh-CN)
-	if m.reusableQQWryRecord.HasRegionData() {
-		if len(record.Subdivisions) == 0 {
-			record.Subdivisions = []SubdivisionRecord{{
-				Names: map[string]string{"zh-CN": m.reusableQQWryRecord.RegionName},
-			}}
-		} else {
-			if record.Subdivisions[0].Names == nil {
-				record.Subdivisions[0].Names = make(map[string]string)
+	build := func(_, shard int) ([]proxyPrefix, error) {
+		groups := make(map[ProxyRecord]*proxyGroup)
+		for _, addr := range byShard[shard] {
+			proxyRecord := singleIPs[addr]
+			proxy := newProxyRecord(&proxyRecord)
+			if flags := m.allowlist.Flags(addr, 0); flags != 0 && proxy.clearFlags(flags) {
+				suppressions[shard]++
+			}
+			if proxy.toMMDBType(m.proxySources) == nil {
+				dropped[shard]++
+				continue
 			}
-			record.Subdivisions[0].Names["zh-CN"] = m.reusableQQWryRecord.RegionName
+
+			// Sources and seen dates vary per IP; group by flags only so
+			// they do not keep adjacent IPs from coalescing
+			key := proxy.flagsOnly()
+			group, ok := groups[key]
+			if !ok {
+				group = &proxyGroup{}
+				groups[key] = group
+			}
+			group.builder.Add(addr)
+			group.members = append(group.members, proxyIP{addr: addr, proxy: proxy})
 		}
-	}
 
-	// Add Chinese country name if not present
-	if record.Country.Names == nil {
-		record.Country.Names = make(map[string]string)
-	}
-	if _, ok := record.Country.Names["zh-CN"]; !ok {
-		record.Country.Names["zh-CN"] = m.reusableQQWryRecord.CountryName
-	}
-}
+		var prefixes []proxyPrefix
+		for _, group := range groups {
+			ipSet, err := group.builder.IPSet()
+			if err != nil {
+				return nil, fmt.Errorf("failed to coalesce single proxy IPs: %w", err)
+			}
 
-// enrichWithASNData adds ASN information from IPinfo Lite (primary), GeoLite2-ASN (secondary), or RouteViews (tertiary).
-// Uses caching to avoid redundant lookups for IPs within the same ASN network.
-func (m *Merger) enrichWithASNData(ip net.IP, record *MergedRecord) {
-	// Check cache first - if IP is within cached ASN network, reuse the result
-	if m.cachedASNValid && m.cachedASNNetwork != nil && m.cachedASNNetwork.Contains(ip) {
-		if m.cachedASN.Number != 0 {
-			record.ASN = m.cachedASN
-		}
-		return
-	}
-
-	// Cache miss - perform lookups
-	m.cachedASNValid = false
-	m.cachedASNNetwork = nil
-
-	// Priority 1: IPinfo Lite (includes as_domain)
-	m.reusableIPinfoRecord.Reset()
-	if network, _, lookupOK, err := m.ipinfoLite.LookupNetwork(ip); err == nil && lookupOK && m.reusableIPinfoRecord.HasASN() {
-		// Need to do a separate lookup to get the record data since LookupNetwork returns record
-		if err := m.ipinfoLite.LookupTo(ip, &m.reusableIPinfoRecord); err == nil && m.reusableIPinfoRecord.HasASN() {
-			m.stats.IPinfoLiteHits++
-			record.ASN = ASNRecord{
-				Number:       m.reusableIPinfoRecord.GetASNumber(),
-				Organization: m.reusableIPinfoRecord.ASName,
-				Domain:       m.reusableIPinfoRecord.ASDomain,
+			// Both lists are in address order and every member lies in
+			// exactly one prefix: union each prefix's members into it, which
+			// ORs the sources and widens the seen dates
+			slices.SortFunc(group.members, func(a, b proxyIP) int {
+				return a.addr.Compare(b.addr)
+			})
+			i := 0
+			for _, prefix := range ipSet.Prefixes() {
+				proxy := group.members[i].proxy
+				for i++; i < len(group.members) && prefix.Contains(group.members[i].addr); i++ {
+					proxy.union(&group.members[i].proxy)
+				}
+				prefixes = append(prefixes, proxyPrefix{prefix: prefix, proxy: proxy})
 			}
-			// Cache the result
-			m.cachedASN = record.ASN
-			m.cachedASNNetwork = network
-			m.cachedASNValid = true
-			return
 		}
+		slices.SortFunc(prefixes, func(a, b proxyPrefix) int {
+			return a.prefix.Addr().Compare(b.prefix.Addr())
+		})
+		byShard[shard] = nil
+		return prefixes, nil
 	}
 
-	// Priority 2: GeoLite2-ASN
-	m.reusableGeoLiteASNRecord.Reset()
-	if network, _, lookupOK, err := m.geoLiteASN.LookupNetwork(ip); err == nil && lookupOK {
-		if err := m.geoLiteASN.LookupTo(ip, &m.reusableGeoLiteASNRecord); err == nil && m.reusableGeoLiteASNRecord.HasASN() {
-			m.stats.GeoLiteASNHits++
-			record.ASN = ASNRecord{
-				Number:       m.reusableGeoLiteASNRecord.AutonomousSystemNumber,
-				Organization: m.reusableGeoLiteASNRecord.AutonomousSystemOrganization,
+	inserted := 0
+	prefixCount := 0
+	skipped := 0
+
+	insert := func(p proxyPrefix) {
+		size := prefixAddressCount(p.prefix)
+		if err := m.insertProxyNetwork(prefixToIPNet(p.prefix), p.proxy, true); err != nil {
+			// Skip reserved and aliased networks, as in the DB-IP phase
+			if !m.skipInsertError(err) {
+				fmt.Printf("Warning: failed to insert single proxy IPs %s: %v\n", p.prefix, err)
 			}
-			// Cache the result
-			m.cachedASN = record.ASN
-			m.cachedASNNetwork = network
-			m.cachedASNValid = true
+			skipped += size
 			return
 		}
+		inserted += size
+		prefixCount++
 	}
 
-	// Priority 3: RouteViews ASN
-	m.reusableRouteViewsRecord.Reset()
-	if network, _, lookupOK, err := m.routeViewsASN.LookupNetwork(ip); err == nil && lookupOK {
-		if err := m.routeViewsASN.LookupTo(ip, &m.reusableRouteViewsRecord); err == nil && m.reusableRouteViewsRecord.HasASN() {
-			m.stats.RouteViewsASNHits++
-			record.ASN = ASNRecord{
-				Number:       m.reusableRouteViewsRecord.AutonomousSystemNumber,
-				Organization: m.reusableRouteViewsRecord.AutonomousSystemOrganization,
-			}
-			// Cache the result
-			m.cachedASN = record.ASN
-			m.cachedASNNetwork = network
-			m.cachedASNValid = true
-			return
-		}
+	if err := runSharded(numWorkers, len(shards), build, insert); err != nil {
+		return err
+	}
+	for i := range shards {
+		m.stats.AllowlistSuppressions += suppressions[i]
+		skipped += dropped[i]
 	}
 
-	// No ASN found - cache the miss with empty record
-	m.cachedASN = ASNRecord{}
-	m.cachedASNValid = true
+	fmt.Printf("Single proxy IPs: %d inserted as %d prefixes, %d skipped (of %d total)\n",
+		inserted, prefixCount, skipped, len(singleIPs))
+	m.stats.SingleProxyIPsInserted = int64(inserted)
+	m.stats.SingleProxyPrefixesInserted = int64(prefixCount)
+	return nil
 }
 
-// enrichWithProxyData adds proxy/anonymity information from OpenProxyDB, and
-// falls back to the bad ASN list when OpenProxyDB did not already flag the IP
-// as a proxy. Bad-ASN matches overlay IsProxy/IsHosting/IsAnonymous onto any
-// existing proxy record (e.g. a CDN-only entry) without clobbering other
-// flags such as IsCDN or IsTor.
-func (m *Merger) enrichWithProxyData(ip net.IP, record *MergedRecord) {
-	m.reusableOpenproxyDBRecord.Reset()
-	if m.openproxyDB.LookupTo(ip, &m.reusableOpenproxyDBRecord) {
-		m.stats.OpenproxyDBHits++
-		record.Proxy = ProxyRecord{
-			IsProxy:     m.reusableOpenproxyDBRecord.IsProxy,
-			IsVPN:       m.reusableOpenproxyDBRecord.IsVPN,
-			IsTor:       m.reusableOpenproxyDBRecord.IsTor,
-			IsHosting:   m.reusableOpenproxyDBRecord.IsHosting,
-			IsCDN:       m.reusableOpenproxyDBRecord.IsCDN,
-			IsSchool:    m.reusableOpenproxyDBRecord.IsSchool,
-			IsAnonymous: m.reusableOpenproxyDBRecord.IsAnonymous,
-		}
-	}
-
-	if !record.Proxy.IsProxy && record.ASN.Number != 0 && m.badASN.Contains(record.ASN.Number) {
-		m.stats.BadASNHits++
-		record.Proxy.IsProxy = true
-		record.Proxy.IsHosting = true
-		record.Proxy.IsAnonymous = true
+// insertProxyPrefix inserts proxy for prefix after applying the allowlist,
+// splitting the prefix where allowlist entries cover part of it. Returns
+// false if nothing was inserted.
+func (m *Merger) insertProxyPrefix(prefix netip.Prefix, proxy ProxyRecord) bool {
+	ok := false
+	for _, piece := range m.allowlist.Split(prefix) {
+		pieceProxy := proxy
+		if piece.Flags != 0 && pieceProxy.clearFlags(piece.Flags) {
+			m.stats.AllowlistSuppressions++
+		}
+		if pieceProxy.toMMDBType(m.proxySources) == nil {
+			continue
+		}
+		if err := m.insertProxyNetwork(prefixToIPNet(piece.Prefix), pieceProxy, true); err != nil {
+			if !m.skipInsertError(err) {
+				fmt.Printf("Warning: failed to insert proxy range %s: %v\n", piece.Prefix, err)
+			}
+			continue
+		}
+		ok = true
 	}
+	return ok
 }
 
-// processSingleProxyIPs directly inserts every single IP from OpenProxyDB and BadIPList
-// as /32 (IPv4) or /128 (IPv6) networks into the MMDB tree.
-// This ensures complete proxy coverage for individual IPs that would otherwise be missed
-// when only the network base address is checked during enrichment.
-// Uses InsertFunc to merge proxy flags with any existing geo/ASN data in the tree.
-func (m *Merger) processSingleProxyIPs() error {
-	singleIPs := m.openproxyDB.SingleIPs()
-	inserted := 0
-	skipped := 0
-
-	for addr, proxyRecord := range singleIPs {
-		// Build the proxy mmdbtype
-		proxy := ProxyRecord{
-			IsProxy:     proxyRecord.IsProxy,
-			IsVPN:       proxyRecord.IsVPN,
-			IsTor:       proxyRecord.IsTor,
-			IsHosting:   proxyRecord.IsHosting,
-			IsCDN:       proxyRecord.IsCDN,
-			IsSchool:    proxyRecord.IsSchool,
-			IsAnonymous: proxyRecord.IsAnonymous,
-		}
-		proxyMMDB := proxy.toMMDBType()
-		if proxyMMDB == nil {
-			skipped++
-			continue
+// insertProxyNetwork unions proxy into the proxy map of every record
+// covered by network, creating a proxy-only record where the tree is empty.
+// When allowlisted is set, records whose ASN is on the allowlist get the
+// listed flags cleared from proxy first; the prefix entries are expected to
+// have been applied by the caller.
+func (m *Merger) insertProxyNetwork(network *net.IPNet, proxy ProxyRecord, allowlisted bool) error {
+	proxyMMDB := proxy.toMMDBType(m.proxySources)
+	checkASN := allowlisted && m.allowlist.HasASNs()
+
+	// InsertFunc merges with any existing record in the tree.
+	// mmdbwriter shares DataType values across tree leaves for deduplication,
+	// so we must never mutate `existing` — always deep-copy first. We also
+	// union proxy flags with any pre-existing proxy map so e.g. Tor and
+	// Hosting coexist rather than one clobbering the other.
+	return m.tree.InsertFunc(network, func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if existing == nil {
+			return mmdbtype.Map{keyProxy: proxyMMDB}, nil
 		}
 
-		// Convert netip.Addr to net.IP and build /32 or /128 network
-		ip := addr.AsSlice()
-		ones := 32
-		if addr.Is6() {
-			ones = 128
-		}
-		network := &net.IPNet{
-			IP:   ip,
-			Mask: net.CIDRMask(ones, ones),
+		existingMap, ok := existing.(mmdbtype.Map)
+		if !ok {
+			return mmdbtype.Map{keyProxy: proxyMMDB}, nil
 		}
 
-		// InsertFunc merges with any existing record in the tree.
-		// mmdbwriter shares DataType values across tree leaves for deduplication,
-		// so we must never mutate `existing` — always deep-copy first. We also
-		// union proxy flags with any pre-existing proxy map so e.g. Tor and
-		// Hosting coexist rather than one clobbering the other.
-		err := m.tree.InsertFunc(network, func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
-			if existing == nil {
-				return mmdbtype.Map{keyProxy: proxyMMDB}, nil
+		leafProxyMMDB := proxyMMDB
+		if checkASN {
+			if flags := m.allowlist.ASNFlags(asnNumberFromMMDB(existingMap)); flags != 0 {
+				leafProxy := proxy
+				if leafProxy.clearFlags(flags) {
+					m.stats.AllowlistSuppressions++
+					leafProxyMMDB = leafProxy.toMMDBType(m.proxySources)
+					if leafProxyMMDB == nil {
+						return existing, nil
+					}
+				}
 			}
+		}
 
-			existingMap, ok := existing.(mmdbtype.Map)
-			if !ok {
-				return mmdbtype.Map{keyProxy: proxyMMDB}, nil
-			}
+		copied := existingMap.Copy().(mmdbtype.Map)
+		if prev, hasPrev := copied[keyProxy].(mmdbtype.Map); hasPrev {
+			copied[keyProxy] = unionProxyMaps(prev, leafProxyMMDB, m.proxySources)
+		} else {
+			copied[keyProxy] = leafProxyMMDB
+		}
+		return copied, nil
+	})
+}
 
-			copied := existingMap.Copy().(mmdbtype.Map)
-			if prev, hasPrev := copied[keyProxy].(mmdbtype.Map); hasPrev {
-				copied[keyProxy] = unionProxyMaps(prev, proxyMMDB)
-			} else {
-				copied[keyProxy] = proxyMMDB
-			}
-			return copied, nil
-		})
+// asnNumberFromMMDB returns the ASN of an encoded record, or 0 if it has none
+func asnNumberFromMMDB(record mmdbtype.Map) uint32 {
+	asn, ok := record[keyASN].(mmdbtype.Map)
+	if !ok {
+		return 0
+	}
+	number, _ := asn[keyASNumber].(mmdbtype.Uint32)
+	return uint32(number)
+}
 
-		if err != nil {
-			// Silently skip reserved and aliased networks — consistent with DB-IP phase
-			var aliasedErr *mmdbwriter.AliasedNetworkError
-			var reservedErr *mmdbwriter.ReservedNetworkError
-			if errors.As(err, &aliasedErr) || errors.As(err, &reservedErr) {
-				skipped++
-				continue
-			}
-			fmt.Printf("Warning: failed to insert single proxy IP %s: %v\n", addr, err)
-			skipped++
-			continue
-		}
-		inserted++
+// prefixToIPNet converts a netip.Prefix to the net.IPNet form used by mmdbwriter
+func prefixToIPNet(prefix netip.Prefix) *net.IPNet {
+	addr := prefix.Addr()
+	return &net.IPNet{
+		IP:   addr.AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), addr.BitLen()),
 	}
+}
 
-	fmt.Printf("Single proxy IPs: %d inserted, %d skipped (of %d total)\n", inserted, skipped, len(singleIPs))
-	m.stats.SingleProxyIPsInserted = int64(inserted)
-	return nil
+// prefixAddressCount returns the number of addresses in prefix, saturating
+// for IPv6 prefixes too large to count in an int
+func prefixAddressCount(prefix netip.Prefix) int {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits >= 62 {
+		return math.MaxInt
+	}
+	return 1 << hostBits
 }
 
-// insertWithMerge inserts a record, merging with existing data if present
-func (m *Merger) insertWithMerge(network *net.IPNet, record *MergedRecord) error {
+// insertWithMerge inserts an encoded record, merging with existing data if present.
+// newMap is shared by every leaf it lands in, so it must not be mutated afterwards.
+func (m *Merger) insertWithMerge(network *net.IPNet, newMap mmdbtype.Map) error {
 	return m.tree.InsertFunc(network, func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
 		if existing == nil {
-			return record.ToMMDBType(), nil
+			return newMap, nil
 		}
 
 		existingMap, ok := existing.(mmdbtype.Map)
 		if !ok {
-			return record.ToMMDBType(), nil
+			return newMap, nil
 		}
 
-		newMap := record.ToMMDBType()
 		return mergeMMDBMaps(existingMap, newMap), nil
 	})
 }
@@ -897,19 +968,14 @@ func mergeMMDBMaps(existing, new mmdbtype.Map) mmdbtype.Map {
 	return result
 }
 
-// unionProxyMaps returns a fresh map containing the union of boolean flag keys
-// from a and b. Every value in the inputs is a mmdbtype.Bool(true) (the proxy
-// encoder omits false flags), so a key present in either map means "true".
-// Neither input is mutated.
-func unionProxyMaps(a, b mmdbtype.Map) mmdbtype.Map {
-	result := mmdbtype.Map{}
-	for k, v := range a {
-		result[k] = v
-	}
-	for k, v := range b {
-		result[k] = v
-	}
-	return result
+// unionProxyMaps returns a fresh map containing the union of the proxy
+// records encoded in a and b: flags and sources are OR'd and the seen-date
+// window is widened to cover both. Neither input is mutated.
+func unionProxyMaps(a, b mmdbtype.Map, sources *reader.ProxySources) mmdbtype.Map {
+	result := proxyRecordFromMMDB(a, sources)
+	other := proxyRecordFromMMDB(b, sources)
+	result.union(&other)
+	return result.toMMDBType(sources)
 }
 
 // Tree returns the mmdbwriter tree for writing
@@ -929,12 +995,51 @@ func (m *Merger) printStats() {
 	fmt.Printf("  GeoLite2-ASN hits: %d\n", m.stats.GeoLiteASNHits)
 	fmt.Printf("  IPinfo Lite hits: %d\n", m.stats.IPinfoLiteHits)
 	fmt.Printf("  RouteViews ASN hits: %d\n", m.stats.RouteViewsASNHits)
+	if len(config.RIBFiles) > 0 {
+		fmt.Printf("  RIB/pfx2as ASN hits: %d\n", m.stats.RIBASNHits)
+	}
+	fmt.Printf("  ASN names filled from other sources: %d\n", m.stats.ASNNamesFilled)
+	if config.ASNReportFile != "" {
+		fmt.Printf("  ASN source disagreements: %d\n", m.stats.ASNDisagreements)
+	}
 	fmt.Printf("  DB-IP supplementary records: %d\n", m.stats.DBIPHits)
 	fmt.Printf("  GeoWhois Country fallback hits: %d\n", m.stats.GeoWhoisCountryHits)
+	fmt.Printf("  RIR delegation hits: %d (%d registered countries filled)\n", m.stats.RIRHits, m.stats.RIRCountryFills)
 	fmt.Printf("  QQWry (Chunzhen) China enrichment hits: %d\n", m.stats.QQWryHits)
 	fmt.Printf("  OpenProxyDB proxy enrichment hits: %d\n", m.stats.OpenproxyDBHits)
 	fmt.Printf("  Bad ASN fallback hits: %d\n", m.stats.BadASNHits)
-	fmt.Printf("  Single proxy IPs inserted (/32, /128): %d\n", m.stats.SingleProxyIPsInserted)
+	fmt.Printf("  Cloud provider range hits: %d\n", m.stats.CloudRangeHits)
+	fmt.Printf("  Connection types assigned: %d\n", m.stats.ConnectionTypeHits)
+	fmt.Printf("  Proxy CIDR ranges inserted: %d\n", m.stats.ProxyCIDRsInserted)
+	fmt.Printf("  Feed prefixes inserted: %d\n", m.stats.FeedPrefixesInserted)
+	fmt.Printf("  Cloud provider ranges inserted: %d\n", m.stats.CloudRangesInserted)
+	fmt.Printf("  Single proxy IPs inserted: %d (as %d coalesced prefixes)\n",
+		m.stats.SingleProxyIPsInserted, m.stats.SingleProxyPrefixesInserted)
+	if config.IncludeReservedNetworks {
+		fmt.Printf("  Special-purpose networks inserted: %d\n", m.stats.SpecialNetworksInserted)
+		fmt.Printf("  Unallocated prefixes inserted: %d\n", m.stats.UnallocatedPrefixesInserted)
+	}
+	fmt.Printf("  Reserved/aliased network inserts skipped: %d\n", m.stats.ReservedNetworkSkips)
+	fmt.Printf("  Local overrides applied: %d\n", m.stats.OverridesApplied)
+	fmt.Printf("  Allowlist suppressions: %d\n", m.stats.AllowlistSuppressions)
 	fmt.Printf("  Empty records skipped: %d\n", m.stats.EmptyRecords)
 	fmt.Printf("  Final network count: %d\n", m.stats.ProcessedNetworks)
+
+	fmt.Println("Range cache hit rates:")
+	caches := []struct {
+		name  string
+		stats CacheStats
+	}{
+		{"IPinfo Lite", m.stats.IPinfoLiteCache},
+		{"GeoLite2-ASN", m.stats.GeoLiteASNCache},
+		{"RouteViews ASN", m.stats.RouteViewsASNCache},
+		{"RIB/pfx2as", m.stats.RIBCache},
+		{"GeoWhois Country", m.stats.GeoWhoisCache},
+		{"QQWry", m.stats.QQWryCache},
+		{"OpenProxyDB", m.stats.OpenProxyDBCache},
+	}
+	for _, c := range caches {
+		fmt.Printf("  %s: %.1f%% (%d of %d lookups)\n",
+			c.name, c.stats.HitRate()*100, c.stats.Hits, c.stats.Hits+c.stats.Misses)
+	}
 }
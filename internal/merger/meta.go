@@ -0,0 +1,73 @@
+package merger
+
+import (
+	"net"
+
+	"merged-ip-data/internal/config"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Keys of meta.source_networks, one per source
+var (
+	keySourceGeoLiteCity   = mmdbtype.String("geolite2_city")
+	keySourceDBIPCity      = mmdbtype.String("dbip_city")
+	keySourceIPinfoLite    = mmdbtype.String("ipinfo_lite")
+	keySourceGeoLiteASN    = mmdbtype.String("geolite2_asn")
+	keySourceRouteViewsASN = mmdbtype.String("routeviews_asn")
+	keySourceRIB           = mmdbtype.String("rib")
+	keySourceGeoWhois      = mmdbtype.String("geowhois")
+	keySourceRIR           = mmdbtype.String("rir")
+	keySourceOpenProxyDB   = mmdbtype.String("openproxydb")
+	keySourceCloud         = mmdbtype.String("cloud")
+)
+
+// networkBits returns the prefix length of a network returned by a reader,
+// counted within its own address family
+func networkBits(network *net.IPNet) uint8 {
+	ones, _ := network.Mask.Size()
+	return uint8(ones)
+}
+
+// toMMDBType returns nil unless the source network mode is enabled
+// (config.SourceNetworks), so the default output is unchanged
+func (m *MetaRecord) toMMDBType() mmdbtype.Map {
+	if !config.SourceNetworks {
+		return nil
+	}
+	sourceNetworks := m.SourceNetworks.toMMDBType()
+	if sourceNetworks == nil {
+		return nil
+	}
+	return mmdbtype.Map{keySourceNetworks: sourceNetworks}
+}
+
+func (s *SourceNetworksRecord) toMMDBType() mmdbtype.Map {
+	fields := [...]struct {
+		key  mmdbtype.String
+		bits uint8
+	}{
+		{keySourceGeoLiteCity, s.GeoLiteCity},
+		{keySourceDBIPCity, s.DBIPCity},
+		{keySourceIPinfoLite, s.IPinfoLite},
+		{keySourceGeoLiteASN, s.GeoLiteASN},
+		{keySourceRouteViewsASN, s.RouteViewsASN},
+		{keySourceRIB, s.RIB},
+		{keySourceGeoWhois, s.GeoWhois},
+		{keySourceRIR, s.RIR},
+		{keySourceOpenProxyDB, s.OpenProxyDB},
+		{keySourceCloud, s.Cloud},
+	}
+
+	var result mmdbtype.Map
+	for _, f := range fields {
+		if f.bits == 0 {
+			continue
+		}
+		if result == nil {
+			result = mmdbtype.Map{}
+		}
+		result[f.key] = mmdbtype.Uint16(f.bits)
+	}
+	return result
+}
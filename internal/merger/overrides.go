@@ -0,0 +1,206 @@
+package merger
+
+import (
+	"errors"
+	"fmt"
+
+	"merged-ip-data/internal/interner"
+	"merged-ip-data/internal/reader"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// applyASOrgOverride renames the AS organization (and sets the domain, when
+// given) for ASNs listed in the local as-orgs override file
+func applyASOrgOverride(overrides *reader.Overrides, asn *ASNRecord) {
+	override, ok := overrides.ASOrg(asn.Number)
+	if !ok {
+		return
+	}
+	asn.Organization = override.Organization
+	if override.Domain != "" {
+		asn.Domain = override.Domain
+	}
+}
+
+// processOverrides applies the local prefix overrides on top of everything
+// inserted so far. It runs last so the overrides win over upstream data. An
+// override that cannot be inserted is reported and skipped, like a network
+// in any other phase.
+func (m *Merger) processOverrides() {
+	if m.overrides == nil {
+		return
+	}
+
+	applied := 0
+	for _, override := range m.overrides.ProxyPrefixes {
+		if err := m.insertProxyOverride(override); err != nil {
+			if !m.skipInsertError(err) {
+				fmt.Printf("Warning: failed to apply proxy override %s: %v\n", override.Prefix, err)
+			}
+			continue
+		}
+		applied++
+	}
+
+	for _, override := range m.overrides.GeoPrefixes {
+		if err := m.insertGeoOverride(override); err != nil {
+			if !m.skipInsertError(err) {
+				fmt.Printf("Warning: failed to apply geo override %s: %v\n", override.Prefix, err)
+			}
+			continue
+		}
+		applied++
+	}
+
+	fmt.Printf("Overrides: %d prefix overrides applied\n", applied)
+	m.stats.OverridesApplied = int64(applied)
+}
+
+// insertProxyOverride clears and then sets the override flags on the proxy
+// record of every leaf in the override prefix. The record lists override in
+// its sources unless clearing left it without any flag.
+func (m *Merger) insertProxyOverride(override reader.ProxyOverride) error {
+	var set ProxyRecord
+	if override.Set != 0 {
+		var rec reader.OpenproxyDBRecord
+		rec.SetFlags(override.Set)
+		set = newProxyRecord(&rec)
+	}
+
+	return m.tree.InsertFunc(prefixToIPNet(override.Prefix), func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+		var result mmdbtype.Map
+		if existingMap, ok := existing.(mmdbtype.Map); ok {
+			// Never mutate existing: mmdbwriter shares values between leaves
+			result = existingMap.Copy().(mmdbtype.Map)
+		} else {
+			result = mmdbtype.Map{}
+		}
+
+		var proxy ProxyRecord
+		if prev, ok := result[keyProxy].(mmdbtype.Map); ok {
+			proxy = proxyRecordFromMMDB(prev, m.proxySources)
+		}
+		proxy.clearFlags(override.Clear)
+		proxy.union(&set)
+		if proxy != (ProxyRecord{}) {
+			proxy.Sources |= reader.SourceOverride
+		}
+
+		if proxyMMDB := proxy.toMMDBType(m.proxySources); proxyMMDB != nil {
+			result[keyProxy] = proxyMMDB
+		} else {
+			delete(result, keyProxy)
+		}
+		if len(result) == 0 {
+			return nil, nil
+		}
+		return result, nil
+	})
+}
+
+// insertGeoOverride replaces the country, and the city when one is given,
+// of every record in the override prefix. A record already in the override
+// country keeps its country data. When the country actually changes, the
+// country and continent are taken from the GeoLite2 data for the new country
+// when there is any, and the subdivisions, postal code, location and (if no
+// replacement is given) city are dropped, as they describe the wrong place.
+func (m *Merger) insertGeoOverride(override reader.GeoOverride) error {
+	locale, ok := m.countryLocales[override.CountryCode]
+	if !ok {
+		locale.country = mmdbtype.Map{keyISOCode: mmdbtype.String(interner.Intern(override.CountryCode))}
+	}
+	var city mmdbtype.Map
+	if override.City != "" {
+		city = mmdbtype.Map{keyNames: mmdbtype.Map{
+			mmdbtype.String("en"): mmdbtype.String(interner.Intern(override.City)),
+		}}
+	}
+
+	return m.tree.InsertFunc(prefixToIPNet(override.Prefix), func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+		var result mmdbtype.Map
+		if existingMap, ok := existing.(mmdbtype.Map); ok {
+			// Never mutate existing: mmdbwriter shares values between leaves
+			result = existingMap.Copy().(mmdbtype.Map)
+		} else {
+			result = mmdbtype.Map{}
+		}
+
+		if !sameCountry(result[keyCountry], override.CountryCode) {
+			delete(result, keyContinent)
+			delete(result, keySubdivisions)
+			delete(result, keyPostal)
+			delete(result, keyLocation)
+			delete(result, keyCity)
+			result[keyCountry] = locale.country
+			if locale.continent != nil {
+				result[keyContinent] = locale.continent
+			}
+		}
+		if city != nil {
+			result[keyCity] = city
+		}
+		return result, nil
+	})
+}
+
+// sameCountry reports whether an encoded country map has the given ISO code
+func sameCountry(country mmdbtype.DataType, isoCode string) bool {
+	countryMap, ok := country.(mmdbtype.Map)
+	if !ok {
+		return false
+	}
+	return countryMap[keyISOCode] == mmdbtype.String(isoCode)
+}
+
+// countryLocale is the encoded country and continent of a country, as the
+// merged records carry them
+type countryLocale struct {
+	country   mmdbtype.Map
+	continent mmdbtype.Map
+}
+
+// countryLocales maps ISO country codes to the first country data seen for
+// them
+type countryLocales map[string]countryLocale
+
+// observe keeps the country and continent of record if its country is not
+// known yet and the record names it. The maps are shared, never modified.
+func (c countryLocales) observe(record mmdbtype.Map) {
+	country, ok := record[keyCountry].(mmdbtype.Map)
+	if !ok {
+		return
+	}
+	isoCode, ok := country[keyISOCode].(mmdbtype.String)
+	if !ok {
+		return
+	}
+	if _, known := c[string(isoCode)]; known {
+		return
+	}
+	if _, ok := country[keyNames]; !ok {
+		return
+	}
+	continent, _ := record[keyContinent].(mmdbtype.Map)
+	c[string(isoCode)] = countryLocale{country: country, continent: continent}
+}
+
+// isSkippableInsertError reports whether err is one of the expected
+// mmdbwriter errors for reserved or aliased networks, which every insert
+// phase skips
+func isSkippableInsertError(err error) bool {
+	var aliasedErr *mmdbwriter.AliasedNetworkError
+	var reservedErr *mmdbwriter.ReservedNetworkError
+	return errors.As(err, &aliasedErr) || errors.As(err, &reservedErr)
+}
+
+// skipInsertError reports whether err is skippable, counting it as a
+// reserved network skip
+func (m *Merger) skipInsertError(err error) bool {
+	if !isSkippableInsertError(err) {
+		return false
+	}
+	m.stats.ReservedNetworkSkips++
+	return true
+}
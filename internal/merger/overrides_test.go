@@ -0,0 +1,110 @@
+package merger
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"merged-ip-data/internal/reader"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// localeRecord returns a merged record as GeoLite2-City data encodes it
+func localeRecord(isoCode, country string, geonameID uint32, continent, city string) mmdbtype.Map {
+	return mmdbtype.Map{
+		keyCountry: mmdbtype.Map{
+			keyISOCode:   mmdbtype.String(isoCode),
+			keyGeonameID: mmdbtype.Uint32(geonameID),
+			keyNames:     mmdbtype.Map{"en": mmdbtype.String(country)},
+		},
+		keyContinent: mmdbtype.Map{"code": mmdbtype.String(continent)},
+		keyCity:      mmdbtype.Map{keyNames: mmdbtype.Map{"en": mmdbtype.String(city)}},
+		keyLocation:  mmdbtype.Map{"time_zone": mmdbtype.String("Etc/UTC")},
+	}
+}
+
+func TestInsertGeoOverride(t *testing.T) {
+	us := localeRecord("US", "United States", 6252001, "NA", "Mountain View")
+	de := localeRecord("DE", "Germany", 2921044, "EU", "Berlin")
+
+	tests := []struct {
+		name          string
+		override      reader.GeoOverride
+		wantCountry   mmdbtype.Map
+		wantContinent mmdbtype.DataType
+		wantCity      string
+		keepsLocation bool
+	}{
+		{
+			name:          "same country new city",
+			override:      reader.GeoOverride{CountryCode: "US", City: "San Jose"},
+			wantCountry:   us[keyCountry].(mmdbtype.Map),
+			wantContinent: us[keyContinent],
+			wantCity:      "San Jose",
+			keepsLocation: true,
+		},
+		{
+			name:          "known country",
+			override:      reader.GeoOverride{CountryCode: "DE"},
+			wantCountry:   de[keyCountry].(mmdbtype.Map),
+			wantContinent: de[keyContinent],
+		},
+		{
+			name:        "unknown country",
+			override:    reader.GeoOverride{CountryCode: "FR", City: "Paris"},
+			wantCountry: mmdbtype.Map{keyISOCode: mmdbtype.String("FR")},
+			wantCity:    "Paris",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := mmdbwriter.New(mmdbwriter.Options{IPVersion: 6, RecordSize: 28})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &Merger{tree: tree, countryLocales: make(countryLocales)}
+			m.countryLocales.observe(us)
+			m.countryLocales.observe(de)
+
+			_, network, _ := net.ParseCIDR("8.8.8.0/24")
+			if err := tree.Insert(network, us); err != nil {
+				t.Fatal(err)
+			}
+			tt.override.Prefix = netip.MustParsePrefix("8.8.8.0/25")
+			if err := m.insertGeoOverride(tt.override); err != nil {
+				t.Fatal(err)
+			}
+
+			_, got := tree.Get(net.ParseIP("8.8.8.1"))
+			record, ok := got.(mmdbtype.Map)
+			if !ok {
+				t.Fatalf("no record after the override")
+			}
+			if !record[keyCountry].Equal(tt.wantCountry) {
+				t.Errorf("country = %v, want %v", record[keyCountry], tt.wantCountry)
+			}
+			if continent := record[keyContinent]; (continent == nil) != (tt.wantContinent == nil) ||
+				continent != nil && !continent.Equal(tt.wantContinent) {
+				t.Errorf("continent = %v, want %v", continent, tt.wantContinent)
+			}
+			var city mmdbtype.DataType
+			if cityMap, ok := record[keyCity].(mmdbtype.Map); ok {
+				city = cityMap[keyNames].(mmdbtype.Map)["en"]
+			}
+			if tt.wantCity == "" && city != nil || tt.wantCity != "" && city != mmdbtype.String(tt.wantCity) {
+				t.Errorf("city = %v, want %q", city, tt.wantCity)
+			}
+			if _, ok := record[keyLocation]; ok != tt.keepsLocation {
+				t.Errorf("location kept = %v, want %v", ok, tt.keepsLocation)
+			}
+
+			// The rest of the network is untouched
+			if _, rest := tree.Get(net.ParseIP("8.8.8.200")); !rest.Equal(us) {
+				t.Errorf("record outside the override = %v, want the original", rest)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package merger
 
 import (
 	"sync"
+	"time"
 
+	"merged-ip-data/internal/config"
 	"merged-ip-data/internal/interner"
+	"merged-ip-data/internal/reader"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 )
@@ -83,6 +86,7 @@ var (
 	keyASNumber          = mmdbtype.String("autonomous_system_number")
 	keyASOrg             = mmdbtype.String("autonomous_system_organization")
 	keyASDomain          = mmdbtype.String("as_domain")
+	keyAlternateNumbers  = mmdbtype.String("alternate_numbers")
 	keyIsProxy           = mmdbtype.String("is_proxy")
 	keyIsVPN             = mmdbtype.String("is_vpn")
 	keyIsTor             = mmdbtype.String("is_tor")
@@ -90,6 +94,29 @@ var (
 	keyIsCDN             = mmdbtype.String("is_cdn")
 	keyIsSchool          = mmdbtype.String("is_school")
 	keyIsAnonymous       = mmdbtype.String("is_anonymous")
+	keyIsAnonblock       = mmdbtype.String("is_anonblock")
+	keyIsOpenProxy       = mmdbtype.String("is_open_proxy")
+	keyIsRangeblock      = mmdbtype.String("is_rangeblock")
+	keyIsTorExit         = mmdbtype.String("is_tor_exit")
+	keySources           = mmdbtype.String("sources")
+	keyFirstSeen         = mmdbtype.String("first_seen")
+	keyLastSeen          = mmdbtype.String("last_seen")
+	keyRiskScore         = mmdbtype.String("risk_score")
+	keyBadASNCategories  = mmdbtype.String("bad_asn_categories")
+	keyType              = mmdbtype.String("type")
+	keyHosting           = mmdbtype.String("hosting")
+	keyProvider          = mmdbtype.String("provider")
+	keyService           = mmdbtype.String("service")
+	keyRegion            = mmdbtype.String("region")
+	keyCDNProvider       = mmdbtype.String("cdn_provider")
+	keyConnection        = mmdbtype.String("connection")
+	keyConnectionType    = mmdbtype.String("connection_type")
+	keyRegistration      = mmdbtype.String("registration")
+	keyRIR               = mmdbtype.String("rir")
+	keyAllocationDate    = mmdbtype.String("allocation_date")
+	keyNetworkType       = mmdbtype.String("network_type")
+	keyMeta              = mmdbtype.String("meta")
+	keySourceNetworks    = mmdbtype.String("source_networks")
 )
 
 // MergedRecord represents the unified record structure for the output database.
@@ -104,6 +131,10 @@ type MergedRecord struct {
 	Subdivisions      []SubdivisionRecord `maxminddb:"subdivisions"`
 	ASN               ASNRecord           `maxminddb:"asn"`
 	Proxy             ProxyRecord         `maxminddb:"proxy"`
+	Hosting           HostingRecord       `maxminddb:"hosting"`
+	Connection        ConnectionRecord    `maxminddb:"connection"`
+	Registration      RegistrationRecord  `maxminddb:"registration"`
+	Meta              MetaRecord          `maxminddb:"meta"`
 }
 
 // CityRecord contains city information with multi-language support
@@ -153,6 +184,52 @@ type ASNRecord struct {
 	Number       uint32 `maxminddb:"autonomous_system_number"`
 	Organization string `maxminddb:"autonomous_system_organization"`
 	Domain       string `maxminddb:"as_domain"`
+
+	// AlternateNumbers lists the other ASNs given by sources that disagree
+	// with the selected one; only set in ASN disagreement mode
+	AlternateNumbers []uint32 `maxminddb:"alternate_numbers"`
+}
+
+// HostingRecord names the cloud provider, service and region publishing the
+// network in its official IP range list
+type HostingRecord struct {
+	Provider string `maxminddb:"provider"`
+	Service  string `maxminddb:"service"`
+	Region   string `maxminddb:"region"`
+}
+
+// ConnectionRecord classifies the kind of network the address is on, one of
+// the reader.Connection* types
+type ConnectionRecord struct {
+	ConnectionType string `maxminddb:"connection_type"`
+}
+
+// RegistrationRecord names the Regional Internet Registry that delegated the
+// network and when
+type RegistrationRecord struct {
+	RIR            string `maxminddb:"rir"`
+	AllocationDate string `maxminddb:"allocation_date"`
+}
+
+// MetaRecord describes how the record was assembled rather than the network
+// itself
+type MetaRecord struct {
+	SourceNetworks SourceNetworksRecord `maxminddb:"source_networks"`
+}
+
+// SourceNetworksRecord holds the prefix length of the network each
+// contributing source answered from; 0 means the source did not contribute
+type SourceNetworksRecord struct {
+	GeoLiteCity   uint8 `maxminddb:"geolite2_city"`
+	DBIPCity      uint8 `maxminddb:"dbip_city"`
+	IPinfoLite    uint8 `maxminddb:"ipinfo_lite"`
+	GeoLiteASN    uint8 `maxminddb:"geolite2_asn"`
+	RouteViewsASN uint8 `maxminddb:"routeviews_asn"`
+	RIB           uint8 `maxminddb:"rib"`
+	GeoWhois      uint8 `maxminddb:"geowhois"`
+	RIR           uint8 `maxminddb:"rir"`
+	OpenProxyDB   uint8 `maxminddb:"openproxydb"`
+	Cloud         uint8 `maxminddb:"cloud"`
 }
 
 // ProxyRecord contains proxy/anonymity detection data from OpenProxyDB
@@ -160,15 +237,183 @@ type ProxyRecord struct {
 	IsProxy     bool `maxminddb:"is_proxy"`
 	IsVPN       bool `maxminddb:"is_vpn"`
 	IsTor       bool `maxminddb:"is_tor"`
+	IsTorExit   bool `maxminddb:"is_tor_exit"`
 	IsHosting   bool `maxminddb:"is_hosting"`
 	IsCDN       bool `maxminddb:"is_cdn"`
 	IsSchool    bool `maxminddb:"is_school"`
 	IsAnonymous bool `maxminddb:"is_anonymous"`
+
+	// Raw OpenProxyDB categories that feed IsProxy
+	IsAnonblock  bool `maxminddb:"is_anonblock"`
+	IsOpenProxy  bool `maxminddb:"is_open_proxy"`
+	IsRangeblock bool `maxminddb:"is_rangeblock"`
+
+	// Evidence for the flags above
+	Sources   reader.ProxySource `maxminddb:"sources"`
+	FirstSeen string             `maxminddb:"first_seen"`
+	LastSeen  string             `maxminddb:"last_seen"`
+
+	// Categories of the bad-ASN list entry that contributed flags, if any
+	BadASNCategory reader.BadASNCategory `maxminddb:"bad_asn_categories"`
+
+	// Organization announcing the anycast prefix, when IsCDN comes from it
+	CDNProvider string `maxminddb:"cdn_provider"`
+}
+
+// newProxyRecord converts an OpenProxyDB lookup result into the output proxy record
+func newProxyRecord(rec *reader.OpenproxyDBRecord) ProxyRecord {
+	return ProxyRecord{
+		IsProxy:      rec.IsProxy,
+		IsVPN:        rec.IsVPN,
+		IsTor:        rec.IsTor,
+		IsTorExit:    rec.IsTorExit,
+		IsHosting:    rec.IsHosting,
+		IsCDN:        rec.IsCDN,
+		IsSchool:     rec.IsSchool,
+		IsAnonymous:  rec.IsAnonymous,
+		IsAnonblock:  rec.IsAnonblock,
+		IsOpenProxy:  rec.IsOpenProxy,
+		IsRangeblock: rec.IsRangeblock,
+		Sources:      rec.Sources,
+		FirstSeen:    rec.FirstSeen,
+		LastSeen:     rec.LastSeen,
+		CDNProvider:  rec.CDNProvider,
+	}
+}
+
+// flagsOnly returns p without its evidence (sources and seen dates), which
+// differs between addresses that carry the same flags
+func (p ProxyRecord) flagsOnly() ProxyRecord {
+	p.Sources = 0
+	p.FirstSeen = ""
+	p.LastSeen = ""
+	return p
+}
+
+// union ORs every flag and source of other into p and widens the
+// first/last-seen window to cover both records
+func (p *ProxyRecord) union(other *ProxyRecord) {
+	p.IsProxy = p.IsProxy || other.IsProxy
+	p.IsVPN = p.IsVPN || other.IsVPN
+	p.IsTor = p.IsTor || other.IsTor
+	p.IsTorExit = p.IsTorExit || other.IsTorExit
+	p.IsHosting = p.IsHosting || other.IsHosting
+	p.IsCDN = p.IsCDN || other.IsCDN
+	p.IsSchool = p.IsSchool || other.IsSchool
+	p.IsAnonymous = p.IsAnonymous || other.IsAnonymous
+	p.IsAnonblock = p.IsAnonblock || other.IsAnonblock
+	p.IsOpenProxy = p.IsOpenProxy || other.IsOpenProxy
+	p.IsRangeblock = p.IsRangeblock || other.IsRangeblock
+	p.Sources |= other.Sources
+	p.BadASNCategory |= other.BadASNCategory
+	p.FirstSeen = reader.EarlierDate(p.FirstSeen, other.FirstSeen)
+	p.LastSeen = reader.LaterDate(p.LastSeen, other.LastSeen)
+	if p.CDNProvider == "" {
+		p.CDNProvider = other.CDNProvider
+	}
+}
+
+// clearFlags clears every flag in flags and reports whether anything was
+// set before. Clearing proxy also clears the raw proxy categories, clearing
+// Tor also clears Tor exit, and IsAnonymous is dropped once no anonymizing
+// flag remains. A record left without any flag loses its evidence too.
+func (p *ProxyRecord) clearFlags(flags reader.ProxyFlag) bool {
+	if flags&reader.FlagProxy != 0 {
+		flags |= reader.FlagAnonblock | reader.FlagOpenProxy | reader.FlagRangeblock
+	}
+	if flags&reader.FlagTor != 0 {
+		flags |= reader.FlagTorExit
+	}
+
+	before := *p
+	p.IsProxy = p.IsProxy && flags&reader.FlagProxy == 0
+	p.IsVPN = p.IsVPN && flags&reader.FlagVPN == 0
+	p.IsTor = p.IsTor && flags&reader.FlagTor == 0
+	p.IsTorExit = p.IsTorExit && flags&reader.FlagTorExit == 0
+	p.IsHosting = p.IsHosting && flags&reader.FlagHosting == 0
+	p.IsCDN = p.IsCDN && flags&reader.FlagCDN == 0
+	p.IsSchool = p.IsSchool && flags&reader.FlagSchool == 0
+	p.IsAnonblock = p.IsAnonblock && flags&reader.FlagAnonblock == 0
+	p.IsOpenProxy = p.IsOpenProxy && flags&reader.FlagOpenProxy == 0
+	p.IsRangeblock = p.IsRangeblock && flags&reader.FlagRangeblock == 0
+	p.IsAnonymous = p.IsAnonymous && flags&reader.FlagAnonymous == 0 && (p.IsProxy || p.IsVPN || p.IsTor)
+	if !p.IsCDN {
+		p.CDNProvider = ""
+	}
+
+	if !p.IsProxy && !p.IsVPN && !p.IsTor && !p.IsHosting && !p.IsCDN && !p.IsSchool && !p.IsAnonymous {
+		*p = ProxyRecord{}
+	}
+	return *p != before
+}
+
+// proxyRecordFromMMDB decodes a proxy map previously produced by
+// ProxyRecord.toMMDBType. Unknown keys are ignored, as are the derived
+// risk_score and type, which toMMDBType recomputes from the flags. sources
+// names the source bits.
+func proxyRecordFromMMDB(m mmdbtype.Map, sources *reader.ProxySources) ProxyRecord {
+	var p ProxyRecord
+	for k, v := range m {
+		switch k {
+		case keyIsProxy:
+			p.IsProxy = v == mmdbtype.Bool(true)
+		case keyIsVPN:
+			p.IsVPN = v == mmdbtype.Bool(true)
+		case keyIsTor:
+			p.IsTor = v == mmdbtype.Bool(true)
+		case keyIsTorExit:
+			p.IsTorExit = v == mmdbtype.Bool(true)
+		case keyIsHosting:
+			p.IsHosting = v == mmdbtype.Bool(true)
+		case keyIsCDN:
+			p.IsCDN = v == mmdbtype.Bool(true)
+		case keyIsSchool:
+			p.IsSchool = v == mmdbtype.Bool(true)
+		case keyIsAnonymous:
+			p.IsAnonymous = v == mmdbtype.Bool(true)
+		case keyIsAnonblock:
+			p.IsAnonblock = v == mmdbtype.Bool(true)
+		case keyIsOpenProxy:
+			p.IsOpenProxy = v == mmdbtype.Bool(true)
+		case keyIsRangeblock:
+			p.IsRangeblock = v == mmdbtype.Bool(true)
+		case keySources:
+			if names, ok := v.(mmdbtype.Slice); ok {
+				for _, name := range names {
+					if str, ok := name.(mmdbtype.String); ok {
+						p.Sources |= sources.FromName(string(str))
+					}
+				}
+			}
+		case keyBadASNCategories:
+			if names, ok := v.(mmdbtype.Slice); ok {
+				for _, name := range names {
+					if str, ok := name.(mmdbtype.String); ok {
+						p.BadASNCategory |= reader.BadASNCategoryFromName(string(str))
+					}
+				}
+			}
+		case keyFirstSeen:
+			if str, ok := v.(mmdbtype.String); ok {
+				p.FirstSeen = string(str)
+			}
+		case keyLastSeen:
+			if str, ok := v.(mmdbtype.String); ok {
+				p.LastSeen = string(str)
+			}
+		case keyCDNProvider:
+			if str, ok := v.(mmdbtype.String); ok {
+				p.CDNProvider = string(str)
+			}
+		}
+	}
+	return p
 }
 
 // ToMMDBType converts the MergedRecord to mmdbtype.Map for insertion into the database.
-// Only non-empty fields are included to minimize database size.
-func (r *MergedRecord) ToMMDBType() mmdbtype.Map {
+// Only non-empty fields are included to minimize database size. sources
+// names the proxy source bits.
+func (r *MergedRecord) ToMMDBType(sources *reader.ProxySources) mmdbtype.Map {
 	// Convert all sub-records first
 	city := r.City.toMMDBType()
 	continent := r.Continent.toMMDBType()
@@ -178,7 +423,11 @@ func (r *MergedRecord) ToMMDBType() mmdbtype.Map {
 	regCountry := r.RegisteredCountry.toMMDBType()
 	subdivisions := r.subdivisionsToMMDBType()
 	asn := r.ASN.toMMDBType()
-	proxy := r.Proxy.toMMDBType()
+	proxy := r.Proxy.toMMDBType(sources)
+	hosting := r.Hosting.toMMDBType()
+	connection := r.Connection.toMMDBType()
+	registration := r.Registration.toMMDBType()
+	meta := r.Meta.toMMDBType()
 
 	// Count non-nil fields to allocate exact capacity
 	count := 0
@@ -209,6 +458,18 @@ func (r *MergedRecord) ToMMDBType() mmdbtype.Map {
 	if proxy != nil {
 		count++
 	}
+	if hosting != nil {
+		count++
+	}
+	if connection != nil {
+		count++
+	}
+	if registration != nil {
+		count++
+	}
+	if meta != nil {
+		count++
+	}
 
 	if count == 0 {
 		return nil
@@ -243,6 +504,18 @@ func (r *MergedRecord) ToMMDBType() mmdbtype.Map {
 	if proxy != nil {
 		result[keyProxy] = proxy
 	}
+	if hosting != nil {
+		result[keyHosting] = hosting
+	}
+	if connection != nil {
+		result[keyConnection] = connection
+	}
+	if registration != nil {
+		result[keyRegistration] = registration
+	}
+	if meta != nil {
+		result[keyMeta] = meta
+	}
 
 	return result
 }
@@ -458,6 +731,28 @@ func (r *MergedRecord) subdivisionsToMMDBType() mmdbtype.Slice {
 	return result
 }
 
+// fillNames fills an empty organization or domain from the names collected
+// across all ASN sources. Returns true if anything was filled.
+func (a *ASNRecord) fillNames(names *reader.ASNNames) bool {
+	if a.Organization != "" && a.Domain != "" {
+		return false
+	}
+	name, ok := names.Lookup(a.Number)
+	if !ok {
+		return false
+	}
+	filled := false
+	if a.Organization == "" && name.Organization != "" {
+		a.Organization = name.Organization
+		filled = true
+	}
+	if a.Domain == "" && name.Domain != "" {
+		a.Domain = name.Domain
+		filled = true
+	}
+	return filled
+}
+
 func (a *ASNRecord) toMMDBType() mmdbtype.Map {
 	// Count non-empty fields first to avoid over-allocation
 	count := 0
@@ -470,6 +765,9 @@ func (a *ASNRecord) toMMDBType() mmdbtype.Map {
 	if a.Domain != "" {
 		count++
 	}
+	if len(a.AlternateNumbers) > 0 {
+		count++
+	}
 	if count == 0 {
 		return nil
 	}
@@ -488,10 +786,18 @@ func (a *ASNRecord) toMMDBType() mmdbtype.Map {
 		result[keyASDomain] = mmdbtype.String(interner.Intern(a.Domain))
 	}
 
+	if len(a.AlternateNumbers) > 0 {
+		alternates := make(mmdbtype.Slice, len(a.AlternateNumbers))
+		for i, asn := range a.AlternateNumbers {
+			alternates[i] = mmdbtype.Uint32(asn)
+		}
+		result[keyAlternateNumbers] = alternates
+	}
+
 	return result
 }
 
-func (p *ProxyRecord) toMMDBType() mmdbtype.Map {
+func (p *ProxyRecord) toMMDBType(sources *reader.ProxySources) mmdbtype.Map {
 	// Count non-empty fields first to avoid over-allocation
 	count := 0
 	if p.IsProxy {
@@ -503,6 +809,9 @@ func (p *ProxyRecord) toMMDBType() mmdbtype.Map {
 	if p.IsTor {
 		count++
 	}
+	if p.IsTorExit {
+		count++
+	}
 	if p.IsHosting {
 		count++
 	}
@@ -515,9 +824,34 @@ func (p *ProxyRecord) toMMDBType() mmdbtype.Map {
 	if p.IsAnonymous {
 		count++
 	}
+	if p.IsAnonblock {
+		count++
+	}
+	if p.IsOpenProxy {
+		count++
+	}
+	if p.IsRangeblock {
+		count++
+	}
+	if p.Sources != 0 {
+		count++
+	}
+	if p.BadASNCategory != 0 {
+		count++
+	}
+	if config.ProxySeenDates && p.FirstSeen != "" {
+		count++
+	}
+	if p.LastSeen != "" {
+		count++
+	}
+	if p.CDNProvider != "" {
+		count++
+	}
 	if count == 0 {
 		return nil
 	}
+	count += 2 // risk_score and type
 
 	result := getMapFromPool(count)
 
@@ -530,6 +864,9 @@ func (p *ProxyRecord) toMMDBType() mmdbtype.Map {
 	if p.IsTor {
 		result[keyIsTor] = mmdbtype.Bool(true)
 	}
+	if p.IsTorExit {
+		result[keyIsTorExit] = mmdbtype.Bool(true)
+	}
 	if p.IsHosting {
 		result[keyIsHosting] = mmdbtype.Bool(true)
 	}
@@ -542,10 +879,84 @@ func (p *ProxyRecord) toMMDBType() mmdbtype.Map {
 	if p.IsAnonymous {
 		result[keyIsAnonymous] = mmdbtype.Bool(true)
 	}
+	if p.IsAnonblock {
+		result[keyIsAnonblock] = mmdbtype.Bool(true)
+	}
+	if p.IsOpenProxy {
+		result[keyIsOpenProxy] = mmdbtype.Bool(true)
+	}
+	if p.IsRangeblock {
+		result[keyIsRangeblock] = mmdbtype.Bool(true)
+	}
+	if p.Sources != 0 {
+		result[keySources] = stringSlice(sources.Names(p.Sources))
+	}
+	if p.BadASNCategory != 0 {
+		result[keyBadASNCategories] = stringSlice(p.BadASNCategory.Names())
+	}
+	if config.ProxySeenDates && p.FirstSeen != "" {
+		result[keyFirstSeen] = mmdbtype.String(interner.Intern(p.FirstSeen))
+	}
+	if p.LastSeen != "" {
+		lastSeen := p.LastSeen
+		if !config.ProxySeenDates {
+			lastSeen = seenWeek(lastSeen)
+		}
+		result[keyLastSeen] = mmdbtype.String(interner.Intern(lastSeen))
+	}
+	if p.CDNProvider != "" {
+		result[keyCDNProvider] = mmdbtype.String(interner.Intern(p.CDNProvider))
+	}
+
+	result[keyRiskScore] = mmdbtype.Uint16(p.riskScore(sources))
+	result[keyType] = mmdbtype.String(p.proxyType())
 
 	return result
 }
 
+func (h *HostingRecord) toMMDBType() mmdbtype.Map {
+	if h.Provider == "" {
+		return nil
+	}
+
+	result := mmdbtype.Map{keyProvider: mmdbtype.String(interner.Intern(h.Provider))}
+	if h.Service != "" {
+		result[keyService] = mmdbtype.String(interner.Intern(h.Service))
+	}
+	if h.Region != "" {
+		result[keyRegion] = mmdbtype.String(interner.Intern(h.Region))
+	}
+	return result
+}
+
+func (c *ConnectionRecord) toMMDBType() mmdbtype.Map {
+	if c.ConnectionType == "" {
+		return nil
+	}
+	return mmdbtype.Map{keyConnectionType: mmdbtype.String(interner.Intern(c.ConnectionType))}
+}
+
+func (r *RegistrationRecord) toMMDBType() mmdbtype.Map {
+	if r.RIR == "" {
+		return nil
+	}
+
+	result := mmdbtype.Map{keyRIR: mmdbtype.String(interner.Intern(r.RIR))}
+	if r.AllocationDate != "" {
+		result[keyAllocationDate] = mmdbtype.String(interner.Intern(r.AllocationDate))
+	}
+	return result
+}
+
+// stringSlice converts names to an mmdbtype.Slice of interned strings
+func stringSlice(names []string) mmdbtype.Slice {
+	result := make(mmdbtype.Slice, len(names))
+	for i, name := range names {
+		result[i] = mmdbtype.String(interner.Intern(name))
+	}
+	return result
+}
+
 // IsEmpty checks if the record has no meaningful data
 func (r *MergedRecord) IsEmpty() bool {
 	return r.Country.ISOCode == "" &&
@@ -566,6 +977,10 @@ func (r *MergedRecord) Reset() {
 	r.Subdivisions = nil
 	r.ASN = ASNRecord{}
 	r.Proxy = ProxyRecord{}
+	r.Hosting = HostingRecord{}
+	r.Connection = ConnectionRecord{}
+	r.Registration = RegistrationRecord{}
+	r.Meta = MetaRecord{}
 }
 
 // HasGeoData checks if the record has geographic data
@@ -582,3 +997,15 @@ func (r *MergedRecord) HasASNData() bool {
 func (r *MergedRecord) HasLocationData() bool {
 	return r.Location.HasCoordinates
 }
+
+// seenWeek rounds a YYYY-MM-DD date down to the Monday of its week, so that
+// neighbouring addresses seen on different days still share a record.
+// Dates that do not parse are returned unchanged.
+func seenWeek(date string) string {
+	t, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return date
+	}
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset).Format(time.DateOnly)
+}
@@ -0,0 +1,21 @@
+package merger
+
+import "testing"
+
+func TestSeenWeek(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2026-10-12", "2026-10-12"}, // Monday
+		{"2026-10-14", "2026-10-12"},
+		{"2026-10-18", "2026-10-12"}, // Sunday
+		{"2026-01-01", "2025-12-29"}, // across a year
+		{"unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := seenWeek(tt.date); got != tt.want {
+			t.Errorf("seenWeek(%q) = %q, want %q", tt.date, got, tt.want)
+		}
+	}
+}
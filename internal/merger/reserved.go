@@ -0,0 +1,144 @@
+package merger
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+)
+
+// Values of network_type
+const (
+	NetworkTypePrivate       = "private"
+	NetworkTypeCGNAT         = "cgnat"
+	NetworkTypeDocumentation = "documentation"
+	NetworkTypeMulticast     = "multicast"
+	NetworkTypeLoopback      = "loopback"
+	NetworkTypeLinkLocal     = "link_local"
+	NetworkTypeReserved      = "reserved"
+	NetworkTypeUnallocated   = "unallocated"
+)
+
+// specialPurposeNetworks are the IANA special-purpose ranges annotated in
+// reserved mode. ::/128 and ::1/128 are left out because the IPv4 subtree
+// (::/96) holds 0.0.0.0/8, and the IPv4 alias networks (::ffff:0:0/96,
+// 2001::/32, 2002::/16) cannot hold records of their own.
+var specialPurposeNetworks = []struct {
+	prefix      netip.Prefix
+	networkType string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), NetworkTypeReserved},
+	{netip.MustParsePrefix("10.0.0.0/8"), NetworkTypePrivate},
+	{netip.MustParsePrefix("100.64.0.0/10"), NetworkTypeCGNAT},
+	{netip.MustParsePrefix("127.0.0.0/8"), NetworkTypeLoopback},
+	{netip.MustParsePrefix("169.254.0.0/16"), NetworkTypeLinkLocal},
+	{netip.MustParsePrefix("172.16.0.0/12"), NetworkTypePrivate},
+	{netip.MustParsePrefix("192.0.0.0/24"), NetworkTypeReserved},
+	{netip.MustParsePrefix("192.0.2.0/24"), NetworkTypeDocumentation},
+	{netip.MustParsePrefix("192.168.0.0/16"), NetworkTypePrivate},
+	{netip.MustParsePrefix("198.18.0.0/15"), NetworkTypeReserved},
+	{netip.MustParsePrefix("198.51.100.0/24"), NetworkTypeDocumentation},
+	{netip.MustParsePrefix("203.0.113.0/24"), NetworkTypeDocumentation},
+	{netip.MustParsePrefix("224.0.0.0/4"), NetworkTypeMulticast},
+	{netip.MustParsePrefix("240.0.0.0/4"), NetworkTypeReserved},
+	{netip.MustParsePrefix("64:ff9b::/96"), NetworkTypeReserved},
+	{netip.MustParsePrefix("100::/64"), NetworkTypeReserved},
+	{netip.MustParsePrefix("2001:db8::/32"), NetworkTypeDocumentation},
+	{netip.MustParsePrefix("3fff::/20"), NetworkTypeDocumentation},
+	{netip.MustParsePrefix("fc00::/7"), NetworkTypePrivate},
+	{netip.MustParsePrefix("fe80::/10"), NetworkTypeLinkLocal},
+	{netip.MustParsePrefix("ff00::/8"), NetworkTypeMulticast},
+}
+
+// ipv4AliasNetworks are the IPv6 networks mmdbwriter aliases to the IPv4
+// subtree; inserting into them always fails
+var ipv4AliasNetworks = []netip.Prefix{
+	netip.MustParsePrefix("::ffff:0:0/96"),
+	netip.MustParsePrefix("2001::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// processReservedNetworks annotates the special-purpose ranges, and the
+// address space no RIR has allocated or assigned, with a network_type so
+// lookups in them return an explicit answer. Data already in the tree for
+// the special-purpose ranges is kept alongside network_type; unallocated
+// space is only annotated where the tree holds no data, since a source
+// knowing the network contradicts the delegation files. Unallocated space
+// is only annotated when the delegation files of all five RIRs are
+// available, since a missing registry would make its whole space look
+// unallocated.
+func (m *Merger) processReservedNetworks() error {
+	special := 0
+	for _, n := range specialPurposeNetworks {
+		if err := m.insertNetworkType(n.prefix, n.networkType); err != nil {
+			return fmt.Errorf("failed to insert special-purpose network %s: %w", n.prefix, err)
+		}
+		special++
+	}
+
+	unallocated, ok, err := m.rirDelegations.Unallocated()
+	if err != nil {
+		return fmt.Errorf("failed to compute unallocated space: %w", err)
+	}
+	if !ok {
+		fmt.Printf("Reserved networks: %d special-purpose ranges inserted, unallocated space skipped (RIR delegations incomplete)\n", special)
+		m.stats.SpecialNetworksInserted = int64(special)
+		return nil
+	}
+
+	var builder netipx.IPSetBuilder
+	builder.AddSet(unallocated)
+	for _, n := range specialPurposeNetworks {
+		builder.RemovePrefix(n.prefix)
+	}
+	for _, prefix := range ipv4AliasNetworks {
+		builder.RemovePrefix(prefix)
+	}
+	unallocated, err = builder.IPSet()
+	if err != nil {
+		return fmt.Errorf("failed to compute unallocated space: %w", err)
+	}
+
+	bogons := 0
+	for _, prefix := range unallocated.Prefixes() {
+		if err := m.insertUnallocated(prefix); err != nil {
+			return fmt.Errorf("failed to insert unallocated network %s: %w", prefix, err)
+		}
+		bogons++
+	}
+
+	fmt.Printf("Reserved networks: %d special-purpose ranges and %d unallocated prefixes inserted\n", special, bogons)
+	m.stats.SpecialNetworksInserted = int64(special)
+	m.stats.UnallocatedPrefixesInserted = int64(bogons)
+	return nil
+}
+
+// insertNetworkType sets network_type on every record covered by prefix,
+// creating a record holding only network_type where the tree is empty
+func (m *Merger) insertNetworkType(prefix netip.Prefix, networkType string) error {
+	value := mmdbtype.String(networkType)
+	return m.tree.InsertFunc(prefixToIPNet(prefix), func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+		existingMap, ok := existing.(mmdbtype.Map)
+		if !ok {
+			return mmdbtype.Map{keyNetworkType: value}, nil
+		}
+
+		// Never mutate existing: mmdbwriter shares values between leaves
+		copied := existingMap.Copy().(mmdbtype.Map)
+		copied[keyNetworkType] = value
+		return copied, nil
+	})
+}
+
+// insertUnallocated marks the empty parts of prefix as unallocated, leaving
+// every record that already holds data untouched
+func (m *Merger) insertUnallocated(prefix netip.Prefix) error {
+	value := mmdbtype.Map{keyNetworkType: mmdbtype.String(NetworkTypeUnallocated)}
+	return m.tree.InsertFunc(prefixToIPNet(prefix), func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if existingMap, ok := existing.(mmdbtype.Map); ok && len(existingMap) > 0 {
+			return existing, nil
+		}
+		return value, nil
+	})
+}
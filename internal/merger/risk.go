@@ -0,0 +1,123 @@
+package merger
+
+import (
+	"merged-ip-data/internal/config"
+	"merged-ip-data/internal/reader"
+)
+
+// Proxy type values emitted as proxy.type, in classification priority order
+const (
+	proxyTypeTor         = "tor"
+	proxyTypeVPN         = "vpn"
+	proxyTypePublicProxy = "public_proxy"
+	proxyTypeSchool      = "school"
+	proxyTypeCDN         = "cdn"
+	proxyTypeHosting     = "hosting"
+	proxyTypeResidential = "residential"
+)
+
+// riskScore combines the proxy signals into a 0-100 score using
+// config.RiskWeights and the weights of the feeds in sources. Each signal
+// present adds its weight once.
+func (p *ProxyRecord) riskScore(sources *reader.ProxySources) uint16 {
+	w := &config.RiskWeights
+	score := 0
+
+	if p.IsOpenProxy {
+		score += w.OpenProxy
+	}
+	if p.Sources&reader.SourceBadIPList != 0 {
+		score += w.BadIPList
+	}
+	if p.IsAnonblock {
+		score += w.Anonblock
+	}
+	if p.IsRangeblock {
+		score += w.Rangeblock
+	}
+	if p.IsVPN {
+		score += w.VPN
+	}
+	if p.IsTorExit {
+		score += w.TorExit
+	} else if p.IsTor {
+		score += w.TorRelay
+	}
+	if p.IsHosting {
+		score += w.Hosting
+	}
+	if p.Sources&reader.SourceBadASN != 0 && p.BadASNCategory == 0 {
+		score += w.BadASN
+	}
+	if p.BadASNCategory&reader.BadASNHosting != 0 {
+		score += w.BadASNHosting
+	}
+	if p.BadASNCategory&reader.BadASNVPN != 0 {
+		score += w.BadASNVPN
+	}
+	if p.BadASNCategory&reader.BadASNBulletproof != 0 {
+		score += w.BadASNBulletproof
+	}
+	if p.IsCDN {
+		score += w.CDN
+	}
+	if p.IsSchool {
+		score += w.School
+	}
+	score += sources.FeedWeight(p.Sources)
+
+	return uint16(min(max(score, 0), 100))
+}
+
+// proxyType classifies the record by its strongest signal. Anonymizing
+// services win over infrastructure: a Tor relay in a hosting range is "tor".
+// Records flagged only by anonblock/rangeblock, with no infrastructure
+// signal, are treated as residential.
+func (p *ProxyRecord) proxyType() string {
+	switch {
+	case p.IsTor:
+		return proxyTypeTor
+	case p.IsVPN:
+		return proxyTypeVPN
+	case p.IsOpenProxy || p.Sources&reader.SourceBadIPList != 0:
+		return proxyTypePublicProxy
+	case p.IsSchool:
+		return proxyTypeSchool
+	case p.IsCDN:
+		return proxyTypeCDN
+	case p.IsHosting:
+		return proxyTypeHosting
+	default:
+		return proxyTypeResidential
+	}
+}
+
+// applyBadASNCategory sets only the proxy flags implied by the categories an
+// ASN is listed under: hosting marks hosting, VPN marks an anonymizing VPN and
+// bulletproof hosting marks hosting used as an anonymizing proxy. An
+// uncategorized listing says nothing more specific, so it keeps the proxy,
+// hosting and anonymous flags every bad ASN used to get.
+func (p *ProxyRecord) applyBadASNCategory(category reader.BadASNCategory) {
+	p.BadASNCategory |= category
+	p.Sources |= reader.SourceBadASN
+
+	if category == 0 {
+		p.IsProxy = true
+		p.IsHosting = true
+		p.IsAnonymous = true
+		return
+	}
+
+	if category&reader.BadASNHosting != 0 {
+		p.IsHosting = true
+	}
+	if category&reader.BadASNVPN != 0 {
+		p.IsVPN = true
+		p.IsAnonymous = true
+	}
+	if category&reader.BadASNBulletproof != 0 {
+		p.IsHosting = true
+		p.IsProxy = true
+		p.IsAnonymous = true
+	}
+}
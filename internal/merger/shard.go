@@ -0,0 +1,145 @@
+package merger
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"go4.org/netipx"
+)
+
+// Shard sizes: IPv4 is split into /8s and IPv6 into /12s. Even the busiest
+// shard stays small next to a whole phase, and the many empty IPv6 shards
+// cost next to nothing.
+const (
+	shardBits4 = 8
+	shardBits6 = 12
+)
+
+// ipv4Subtree is where an IPv6 MaxMind DB keeps its IPv4 networks
+var ipv4Subtree = netip.MustParsePrefix("::/96")
+
+// shardPrefixes partitions the address space into shards: the IPv4 /8s
+// followed by the IPv6 /12s, in address order. The IPv6 shards are left out
+// when ipv6 is false, for IPv4-only databases.
+func shardPrefixes(ipv6 bool) []netip.Prefix {
+	count := 1 << shardBits4
+	if ipv6 {
+		count += 1 << shardBits6
+	}
+	shards := make([]netip.Prefix, 0, count)
+
+	for i := 0; i < 1<<shardBits4; i++ {
+		var a [4]byte
+		binary.BigEndian.PutUint32(a[:], uint32(i)<<(32-shardBits4))
+		shards = append(shards, netip.PrefixFrom(netip.AddrFrom4(a), shardBits4))
+	}
+	if ipv6 {
+		for i := 0; i < 1<<shardBits6; i++ {
+			var a [16]byte
+			binary.BigEndian.PutUint16(a[:], uint16(i)<<(16-shardBits6))
+			shards = append(shards, netip.PrefixFrom(netip.AddrFrom16(a), shardBits6))
+		}
+	}
+	return shards
+}
+
+// shardIndex returns the index in shardPrefixes(true) of the shard holding
+// addr. IPv4-mapped addresses belong to the IPv4 shards.
+func shardIndex(addr netip.Addr) int {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		a := addr.As4()
+		return int(binary.BigEndian.Uint32(a[:]) >> (32 - shardBits4))
+	}
+	a := addr.As16()
+	return 1<<shardBits4 + int(binary.BigEndian.Uint16(a[:])>>(16-shardBits6))
+}
+
+// shardParts returns the prefixes to iterate in a MaxMind DB for shard. The
+// IPv6 shard holding the IPv4 subtree leaves it out, since its networks are
+// read through the IPv4 shards.
+func shardParts(shard netip.Prefix) []netip.Prefix {
+	if !shard.Overlaps(ipv4Subtree) {
+		return []netip.Prefix{shard}
+	}
+	var builder netipx.IPSetBuilder
+	builder.AddPrefix(shard)
+	builder.RemovePrefix(ipv4Subtree)
+	set, err := builder.IPSet()
+	if err != nil {
+		return []netip.Prefix{shard}
+	}
+	return set.Prefixes()
+}
+
+// inShard reports whether network starts in shard. Iterating the networks
+// within a shard yields the network containing the whole shard when there is
+// one, so only the shard holding its first address keeps it.
+func inShard(shard netip.Prefix, network *net.IPNet) bool {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	return ok && shard.Contains(addr)
+}
+
+// runSharded builds the batches of shards 0 to shards-1 on numWorkers
+// goroutines and passes every batch to insert, in shard order, on the
+// calling goroutine. build gets the index of the worker running it, so
+// per-worker state needs no locking.
+//
+// mmdbwriter cannot merge trees built separately, so the inserts into the
+// one tree stay serialized; what runs in parallel is reading and enriching
+// each shard into a batch already in address order. Once the inserting
+// goroutine is saturated, more workers do not make a phase faster. At most a
+// few batches per worker wait for insert at any time.
+func runSharded[T any](numWorkers, shards int, build func(worker, shard int) ([]T, error), insert func(T)) error {
+	type batch struct {
+		items []T
+		err   error
+		done  chan struct{}
+	}
+	batches := make([]batch, shards)
+	for i := range batches {
+		batches[i].done = make(chan struct{})
+	}
+
+	// A worker takes a slot before taking a shard, and the slot is only
+	// freed once the shard is inserted. Shards are taken in order, so the
+	// next shard to insert always holds a slot.
+	slots := make(chan struct{}, numWorkers*4)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				slots <- struct{}{}
+				i := int(next.Add(1) - 1)
+				if i >= shards {
+					<-slots
+					return
+				}
+				batches[i].items, batches[i].err = build(worker, i)
+				close(batches[i].done)
+			}
+		}(w)
+	}
+
+	var firstErr error
+	for i := range batches {
+		<-batches[i].done
+		for _, item := range batches[i].items {
+			insert(item)
+		}
+		if batches[i].err != nil && firstErr == nil {
+			firstErr = batches[i].err
+		}
+		batches[i].items = nil
+		<-slots
+	}
+	wg.Wait()
+	return firstErr
+}
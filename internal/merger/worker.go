@@ -2,25 +2,39 @@ package merger
 
 import (
 	"net"
+	"net/netip"
 	"runtime"
 	"sync"
 	"sync/atomic"
 
+	"merged-ip-data/internal/config"
 	"merged-ip-data/internal/reader"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
 )
 
-// workItem represents a unit of work for parallel processing
+// workItem represents a unit of work for parallel processing: a GeoLite2-City
+// network, or a DB-IP network when dbipRecord is set
 type workItem struct {
-	network   *net.IPNet
-	geoRecord reader.GeoLite2CityRecord
+	network    *net.IPNet
+	geoRecord  reader.GeoLite2CityRecord
+	dbipRecord *reader.DBIPCityRecord
 }
 
 // resultItem represents the processed result ready for insertion
 type resultItem struct {
 	network    *net.IPNet
 	mmdbRecord mmdbtype.Map
+
+	// summary holds the ASN and country of a DB-IP result, for the ASN
+	// summaries. DB-IP inserts may be skipped, so they are only observed
+	// once inserted.
+	summary *MergedRecord
+
+	// disagreement is the ASN disagreement found for a DB-IP result, only
+	// recorded once inserted for the same reason
+	disagreement *asnDisagreement
 }
 
 // workerContext holds the per-worker state for enrichment lookups.
@@ -30,27 +44,38 @@ type workerContext struct {
 	ipinfoLite      *reader.IPinfoLiteReader
 	geoLiteASN      *reader.GeoLite2ASNReader
 	routeViewsASN   *reader.RouteViewsASNReader
+	rib             *reader.RIBReader
 	geoWhoisCountry *reader.GeoWhoisCountryReader
+	rirDelegations  *reader.RIRDelegations
 	qqwry           *reader.QQWryReader
 	openproxyDB     *reader.OpenproxyDBReader
+	cloudRanges     *reader.CloudRangesReader
 	badASN          *reader.BadASNReader
+	overrides       *reader.Overrides
+	allowlist       *reader.Allowlist
+	connectionASNs  *reader.ConnectionASNs
+	asnNames        *reader.ASNNames
+	proxySources    *reader.ProxySources
 
 	// Per-worker reusable records (not shared between workers)
-	reusableIPinfoRecord     reader.IPinfoLiteRecord
-	reusableGeoLiteASNRecord reader.GeoLite2ASNRecord
-	reusableRouteViewsRecord reader.RouteViewsASNRecord
-	reusableGeoWhoisRecord   reader.GeoWhoisCountryRecord
-	reusableQQWryRecord      reader.QQWryRecord
-	reusableOpenproxyRecord  reader.OpenproxyDBRecord
-	reusableMergedRecord     MergedRecord
-
-	// Per-worker ASN cache
-	cachedASN        ASNRecord
-	cachedASNNetwork *net.IPNet
-	cachedASNValid   bool
+	reusableRIRRecord    reader.RIRRecord
+	reusableCloudRecord  reader.CloudRangeRecord
+	reusableMergedRecord MergedRecord
+
+	// Per-worker range caches, one per source
+	caches sourceCaches
 
 	// Per-worker statistics (atomically updated)
 	stats workerStats
+
+	// Networks the ASN sources disagree on, in ASN disagreement mode
+	asnDisagreements []asnDisagreement
+
+	// disagreement is the ASN disagreement found for the record being built
+	disagreement *asnDisagreement
+
+	// ASNs seen in this worker's merged records
+	asnSummaries asnSummaries
 }
 
 // workerStats holds per-worker statistics
@@ -59,10 +84,18 @@ type workerStats struct {
 	geoLiteASNHits      int64
 	ipinfoLiteHits      int64
 	routeViewsASNHits   int64
+	ribASNHits          int64
 	geoWhoisCountryHits int64
+	rirHits             int64
+	rirCountryFills     int64
 	qqwryHits           int64
 	openproxyDBHits     int64
 	badASNHits          int64
+	cloudRangeHits      int64
+	allowlistHits       int64
+	connectionTypeHits  int64
+	asnNamesFilled      int64
+	asnDisagreements    int64
 	emptyRecords        int64
 	processedNetworks   int64
 }
@@ -70,9 +103,6 @@ type workerStats struct {
 // workerPool manages a pool of workers for parallel processing
 type workerPool struct {
 	numWorkers int
-	workChan   chan workItem
-	resultChan chan resultItem
-	wg         sync.WaitGroup
 	contexts   []*workerContext
 
 	// Aggregated statistics
@@ -81,78 +111,40 @@ type workerPool struct {
 	statsMu       sync.Mutex
 }
 
-// newWorkerPool creates a new worker pool with the specified number of workers
-func newWorkerPool(
-	numWorkers int,
-	ipinfoLite *reader.IPinfoLiteReader,
-	geoLiteASN *reader.GeoLite2ASNReader,
-	routeViewsASN *reader.RouteViewsASNReader,
-	geoWhoisCountry *reader.GeoWhoisCountryReader,
-	qqwry *reader.QQWryReader,
-	openproxyDB *reader.OpenproxyDBReader,
-	badASN *reader.BadASNReader,
-) *workerPool {
+// newWorkerPool creates a new worker pool with the specified number of
+// workers, each with a context of its own from newContext
+func newWorkerPool(numWorkers int, newContext func() *workerContext) *workerPool {
 	if numWorkers <= 0 {
 		numWorkers = runtime.NumCPU()
 	}
 
-	// Use buffered channels to reduce blocking
-	// Work channel: large buffer to keep workers busy
-	// Result channel: batch size * 2 to allow some buffering
-	workChanSize := numWorkers * 1000
-	resultChanSize := numWorkers * 100
-
 	pool := &workerPool{
 		numWorkers: numWorkers,
-		workChan:   make(chan workItem, workChanSize),
-		resultChan: make(chan resultItem, resultChanSize),
 		contexts:   make([]*workerContext, numWorkers),
 	}
-
-	// Create worker contexts with shared readers but per-worker reusable records
-	for i := 0; i < numWorkers; i++ {
-		pool.contexts[i] = &workerContext{
-			ipinfoLite:      ipinfoLite,
-			geoLiteASN:      geoLiteASN,
-			routeViewsASN:   routeViewsASN,
-			geoWhoisCountry: geoWhoisCountry,
-			qqwry:           qqwry,
-			openproxyDB:     openproxyDB,
-			badASN:          badASN,
-		}
+	for i := range pool.contexts {
+		pool.contexts[i] = newContext()
 	}
 
 	return pool
 }
 
-// start launches all workers
-func (p *workerPool) start() {
-	for i := 0; i < p.numWorkers; i++ {
-		p.wg.Add(1)
-		go p.worker(i)
-	}
-}
-
-// submit sends a work item to the pool
-func (p *workerPool) submit(item workItem) {
-	p.totalNetworks.Add(1)
-	p.workChan <- item
-}
-
-// closeWork signals that no more work will be submitted
-func (p *workerPool) closeWork() {
-	close(p.workChan)
-}
-
-// wait waits for all workers to complete and closes the result channel
-func (p *workerPool) wait() {
-	p.wg.Wait()
-	close(p.resultChan)
-}
-
-// results returns the channel for reading processed results
-func (p *workerPool) results() <-chan resultItem {
-	return p.resultChan
+// run reads the networks of every shard and processes them on the workers.
+// A worker handles a whole shard with its own context, so its range caches
+// see the networks in address order. The results are passed to insert shard
+// by shard, in address order, on the calling goroutine.
+func (p *workerPool) run(shards []netip.Prefix, read func(shard netip.Prefix, process func(workItem)) error, insert func(resultItem)) error {
+	return runSharded(p.numWorkers, len(shards), func(worker, shard int) ([]resultItem, error) {
+		ctx := p.contexts[worker]
+		var results []resultItem
+		err := read(shards[shard], func(item workItem) {
+			p.totalNetworks.Add(1)
+			if result := ctx.processWorkItem(item); result.mmdbRecord != nil {
+				results = append(results, result)
+			}
+		})
+		return results, err
+	}, insert)
 }
 
 // aggregateStats aggregates all worker statistics into the pool stats
@@ -168,49 +160,82 @@ func (p *workerPool) aggregateStats() Stats {
 		stats.GeoLiteASNHits += ctx.stats.geoLiteASNHits
 		stats.IPinfoLiteHits += ctx.stats.ipinfoLiteHits
 		stats.RouteViewsASNHits += ctx.stats.routeViewsASNHits
+		stats.RIBASNHits += ctx.stats.ribASNHits
 		stats.GeoWhoisCountryHits += ctx.stats.geoWhoisCountryHits
+		stats.RIRHits += ctx.stats.rirHits
+		stats.RIRCountryFills += ctx.stats.rirCountryFills
 		stats.QQWryHits += ctx.stats.qqwryHits
 		stats.OpenproxyDBHits += ctx.stats.openproxyDBHits
 		stats.BadASNHits += ctx.stats.badASNHits
+		stats.CloudRangeHits += ctx.stats.cloudRangeHits
+		stats.AllowlistSuppressions += ctx.stats.allowlistHits
+		stats.ConnectionTypeHits += ctx.stats.connectionTypeHits
+		stats.ASNNamesFilled += ctx.stats.asnNamesFilled
+		stats.ASNDisagreements += ctx.stats.asnDisagreements
 		stats.EmptyRecords += ctx.stats.emptyRecords
 		stats.ProcessedNetworks += ctx.stats.processedNetworks
+		ctx.caches.addStats(&stats)
 	}
 
 	return stats
 }
 
-// worker is the main worker goroutine
-func (p *workerPool) worker(id int) {
-	defer p.wg.Done()
-
-	ctx := p.contexts[id]
+// asnDisagreements collects the networks every worker found the ASN sources
+// disagreeing on. Only valid once all workers are done.
+func (p *workerPool) asnDisagreements() []asnDisagreement {
+	var result []asnDisagreement
+	for _, ctx := range p.contexts {
+		result = append(result, ctx.asnDisagreements...)
+	}
+	return result
+}
 
-	for item := range p.workChan {
-		result := ctx.processWorkItem(item)
-		if result.mmdbRecord != nil {
-			p.resultChan <- result
-		}
+// mergeASNSummaries adds the ASNs every worker saw into summaries. Only
+// valid once all workers are done.
+func (p *workerPool) mergeASNSummaries(summaries asnSummaries) {
+	for _, ctx := range p.contexts {
+		summaries.merge(ctx.asnSummaries)
 	}
 }
 
 // processWorkItem processes a single work item and returns the result
 func (ctx *workerContext) processWorkItem(item workItem) resultItem {
 	ctx.reusableMergedRecord.Reset()
+	ctx.disagreement = nil
+	record := &ctx.reusableMergedRecord
 
-	// Build merged record from GeoLite2-City as primary source
-	ctx.buildMergedRecord(item.network, &item.geoRecord)
+	if item.dbipRecord != nil {
+		ctx.buildMergedRecordFromDBIP(item.network, item.dbipRecord)
+	} else {
+		// Build merged record from GeoLite2-City as primary source
+		ctx.buildMergedRecord(item.network, &item.geoRecord)
+	}
 
-	if ctx.reusableMergedRecord.IsEmpty() {
+	if record.IsEmpty() {
 		ctx.stats.emptyRecords++
 		return resultItem{network: item.network, mmdbRecord: nil}
 	}
 
-	ctx.stats.processedNetworks++
-
-	return resultItem{
+	result := resultItem{
 		network:    item.network,
-		mmdbRecord: ctx.reusableMergedRecord.ToMMDBType(),
+		mmdbRecord: record.ToMMDBType(ctx.proxySources),
+	}
+	if item.dbipRecord != nil {
+		result.summary = &MergedRecord{
+			ASN:     record.ASN,
+			Country: CountryRecord{ISOCode: record.Country.ISOCode},
+		}
+		result.disagreement = ctx.disagreement
+		return result
 	}
+
+	ctx.stats.processedNetworks++
+	ctx.asnSummaries.observe(item.network, record)
+	if ctx.disagreement != nil {
+		ctx.stats.asnDisagreements++
+		ctx.asnDisagreements = append(ctx.asnDisagreements, *ctx.disagreement)
+	}
+	return result
 }
 
 // buildMergedRecord creates a merged record for a network using GeoLite2-City as primary
@@ -250,6 +275,8 @@ func (ctx *workerContext) buildMergedRecord(network *net.IPNet, geoRecord *reade
 			Code: geoRecord.Postal.Code,
 		}
 
+		record.Meta.SourceNetworks.GeoLiteCity = networkBits(network)
+
 		record.RegisteredCountry = CountryRecord{
 			GeonameID: geoRecord.RegisteredCountry.GeonameID,
 			ISOCode:   geoRecord.RegisteredCountry.ISOCode,
@@ -268,69 +295,105 @@ func (ctx *workerContext) buildMergedRecord(network *net.IPNet, geoRecord *reade
 		}
 	}
 
+	ctx.enrich(network, record)
+}
+
+// enrich adds the data of every non-geo source to a record built from a geo source
+func (ctx *workerContext) enrich(network *net.IPNet, record *MergedRecord) {
 	ctx.enrichWithASNData(network.IP, record)
+	ctx.checkASNAgreement(network, record)
+	ctx.fillASNNames(record)
+	applyASOrgOverride(ctx.overrides, &record.ASN)
 	ctx.enrichWithCountryFallback(network.IP, record)
+	ctx.enrichWithRIRData(network.IP, record)
 	ctx.enrichWithQQWryData(network.IP, record)
 	ctx.enrichWithProxyData(network.IP, record)
+	ctx.enrichWithConnectionType(record)
 }
 
-// enrichWithASNData adds ASN information with caching
-func (ctx *workerContext) enrichWithASNData(ip net.IP, record *MergedRecord) {
-	// Check cache first
-	if ctx.cachedASNValid && ctx.cachedASNNetwork != nil && ctx.cachedASNNetwork.Contains(ip) {
-		if ctx.cachedASN.Number != 0 {
-			record.ASN = ctx.cachedASN
+// buildMergedRecordFromDBIP creates a merged record for a network using DB-IP as primary geo source
+func (ctx *workerContext) buildMergedRecordFromDBIP(network *net.IPNet, dbipRecord *reader.DBIPCityRecord) {
+	record := &ctx.reusableMergedRecord
+
+	if dbipRecord.HasGeoData() {
+		record.City = CityRecord{
+			Names: map[string]string{"en": dbipRecord.City},
+		}
+
+		record.Country = CountryRecord{
+			ISOCode: dbipRecord.CountryCode,
+		}
+		record.Meta.SourceNetworks.DBIPCity = networkBits(network)
+
+		if dbipRecord.HasLocationData() {
+			record.Location = LocationRecord{
+				Latitude:       float64(dbipRecord.Latitude),
+				Longitude:      float64(dbipRecord.Longitude),
+				TimeZone:       dbipRecord.Timezone,
+				HasCoordinates: true,
+			}
+		}
+
+		if dbipRecord.Postcode != "" {
+			record.Postal = PostalRecord{
+				Code: dbipRecord.Postcode,
+			}
+		}
+
+		if dbipRecord.State1 != "" {
+			record.Subdivisions = []SubdivisionRecord{
+				{
+					Names: map[string]string{"en": dbipRecord.State1},
+				},
+			}
 		}
-		return
 	}
 
-	ctx.cachedASNValid = false
-	ctx.cachedASNNetwork = nil
+	ctx.enrich(network, record)
+}
 
+// enrichWithASNData adds ASN information, each source looked up through its range cache
+func (ctx *workerContext) enrichWithASNData(ip net.IP, record *MergedRecord) {
 	// Priority 1: IPinfo Lite
-	ctx.reusableIPinfoRecord.Reset()
-	if err := ctx.ipinfoLite.LookupTo(ip, &ctx.reusableIPinfoRecord); err == nil && ctx.reusableIPinfoRecord.HasASN() {
+	if e := ctx.caches.lookupIPinfoLite(ctx.ipinfoLite, ip); e.found && e.value.HasASN() {
 		ctx.stats.ipinfoLiteHits++
 		record.ASN = ASNRecord{
-			Number:       ctx.reusableIPinfoRecord.GetASNumber(),
-			Organization: ctx.reusableIPinfoRecord.ASName,
-			Domain:       ctx.reusableIPinfoRecord.ASDomain,
+			Number:       e.value.GetASNumber(),
+			Organization: e.value.ASName,
+			Domain:       e.value.ASDomain,
 		}
-		// Cache (simplified - use lookup result)
-		ctx.cachedASN = record.ASN
-		ctx.cachedASNValid = true
+		record.Meta.SourceNetworks.IPinfoLite = uint8(e.network.Bits())
 		return
 	}
 
 	// Priority 2: GeoLite2-ASN
-	ctx.reusableGeoLiteASNRecord.Reset()
-	if err := ctx.geoLiteASN.LookupTo(ip, &ctx.reusableGeoLiteASNRecord); err == nil && ctx.reusableGeoLiteASNRecord.HasASN() {
+	if e := ctx.caches.lookupGeoLiteASN(ctx.geoLiteASN, ip); e.found && e.value.HasASN() {
 		ctx.stats.geoLiteASNHits++
 		record.ASN = ASNRecord{
-			Number:       ctx.reusableGeoLiteASNRecord.AutonomousSystemNumber,
-			Organization: ctx.reusableGeoLiteASNRecord.AutonomousSystemOrganization,
+			Number:       e.value.AutonomousSystemNumber,
+			Organization: e.value.AutonomousSystemOrganization,
 		}
-		ctx.cachedASN = record.ASN
-		ctx.cachedASNValid = true
+		record.Meta.SourceNetworks.GeoLiteASN = uint8(e.network.Bits())
 		return
 	}
 
 	// Priority 3: RouteViews ASN
-	ctx.reusableRouteViewsRecord.Reset()
-	if err := ctx.routeViewsASN.LookupTo(ip, &ctx.reusableRouteViewsRecord); err == nil && ctx.reusableRouteViewsRecord.HasASN() {
+	if e := ctx.caches.lookupRouteViewsASN(ctx.routeViewsASN, ip); e.found && e.value.HasASN() {
 		ctx.stats.routeViewsASNHits++
 		record.ASN = ASNRecord{
-			Number:       ctx.reusableRouteViewsRecord.AutonomousSystemNumber,
-			Organization: ctx.reusableRouteViewsRecord.AutonomousSystemOrganization,
+			Number:       e.value.AutonomousSystemNumber,
+			Organization: e.value.AutonomousSystemOrganization,
 		}
-		ctx.cachedASN = record.ASN
-		ctx.cachedASNValid = true
+		record.Meta.SourceNetworks.RouteViewsASN = uint8(e.network.Bits())
 		return
 	}
 
-	// No ASN found
-	ctx.cachedASN = ASNRecord{}
-	ctx.cachedASNValid = true
+	// Priority 4: local RIB/pfx2as origins
+	if e := ctx.caches.lookupRIB(ctx.rib, ip); e.found {
+		ctx.stats.ribASNHits++
+		record.ASN = ASNRecord{Number: e.value.Origins[0]}
+		record.Meta.SourceNetworks.RIB = uint8(e.value.Bits)
+	}
 }
 
 // enrichWithCountryFallback adds country information from GeoWhois when country is missing
@@ -339,10 +402,30 @@ func (ctx *workerContext) enrichWithCountryFallback(ip net.IP, record *MergedRec
 		return
 	}
 
-	ctx.reusableGeoWhoisRecord.Reset()
-	if err := ctx.geoWhoisCountry.LookupTo(ip, &ctx.reusableGeoWhoisRecord); err == nil && ctx.reusableGeoWhoisRecord.HasCountry() {
+	if e := ctx.caches.lookupGeoWhois(ctx.geoWhoisCountry, ip); e.found && e.value.HasCountry() {
 		ctx.stats.geoWhoisCountryHits++
-		record.Country.ISOCode = ctx.reusableGeoWhoisRecord.CountryCode
+		record.Country.ISOCode = e.value.CountryCode
+		record.Meta.SourceNetworks.GeoWhois = uint8(e.network.Bits())
+	}
+}
+
+// enrichWithRIRData records the RIR delegation covering the network and
+// fills a missing registered country from it
+func (ctx *workerContext) enrichWithRIRData(ip net.IP, record *MergedRecord) {
+	ctx.reusableRIRRecord.Reset()
+	if !ctx.rirDelegations.LookupTo(ip, &ctx.reusableRIRRecord) {
+		return
+	}
+
+	ctx.stats.rirHits++
+	record.Registration = RegistrationRecord{
+		RIR:            ctx.reusableRIRRecord.Registry,
+		AllocationDate: ctx.reusableRIRRecord.Date,
+	}
+	record.Meta.SourceNetworks.RIR = uint8(ctx.reusableRIRRecord.Bits)
+	if record.RegisteredCountry.ISOCode == "" && ctx.reusableRIRRecord.CountryCode != "" {
+		ctx.stats.rirCountryFills++
+		record.RegisteredCountry.ISOCode = ctx.reusableRIRRecord.CountryCode
 	}
 }
 
@@ -352,34 +435,35 @@ func (ctx *workerContext) enrichWithQQWryData(ip net.IP, record *MergedRecord) {
 		return
 	}
 
-	ctx.reusableQQWryRecord.Reset()
-	if err := ctx.qqwry.LookupTo(ip, &ctx.reusableQQWryRecord); err != nil || !ctx.reusableQQWryRecord.HasGeoData() {
+	e := ctx.caches.lookupQQWry(ctx.qqwry, ip)
+	qq := &e.value
+	if !e.found || !qq.HasGeoData() {
 		return
 	}
 
-	if !ctx.reusableQQWryRecord.IsChina() {
+	if !qq.IsChina() {
 		return
 	}
 
 	ctx.stats.qqwryHits++
 
-	if ctx.reusableQQWryRecord.HasCityData() {
+	if qq.HasCityData() {
 		if record.City.Names == nil {
 			record.City.Names = make(map[string]string)
 		}
-		record.City.Names["zh-CN"] = ctx.reusableQQWryRecord.CityName
+		record.City.Names["zh-CN"] = qq.CityName
 	}
 
-	if ctx.reusableQQWryRecord.HasRegionData() {
+	if qq.HasRegionData() {
 		if len(record.Subdivisions) == 0 {
 			record.Subdivisions = []SubdivisionRecord{{
-				Names: map[string]string{"zh-CN": ctx.reusableQQWryRecord.RegionName},
+				Names: map[string]string{"zh-CN": qq.RegionName},
 			}}
 		} else {
 			if record.Subdivisions[0].Names == nil {
 				record.Subdivisions[0].Names = make(map[string]string)
 			}
-			record.Subdivisions[0].Names["zh-CN"] = ctx.reusableQQWryRecord.RegionName
+			record.Subdivisions[0].Names["zh-CN"] = qq.RegionName
 		}
 	}
 
@@ -387,33 +471,79 @@ func (ctx *workerContext) enrichWithQQWryData(ip net.IP, record *MergedRecord) {
 		record.Country.Names = make(map[string]string)
 	}
 	if _, ok := record.Country.Names["zh-CN"]; !ok {
-		record.Country.Names["zh-CN"] = ctx.reusableQQWryRecord.CountryName
+		record.Country.Names["zh-CN"] = qq.CountryName
+	}
+
+	record.Connection.ConnectionType = qq.ConnectionType()
+}
+
+// checkASNAgreement looks the network up in every ASN source and, when the
+// sources disagree, adds the other ASNs given to record and keeps the
+// disagreement for processWorkItem to report, in ASN disagreement mode
+func (ctx *workerContext) checkASNAgreement(network *net.IPNet, record *MergedRecord) {
+	if config.ASNReportFile == "" {
+		return
+	}
+
+	ipinfoASN := ctx.caches.lookupIPinfoLite(ctx.ipinfoLite, network.IP).value.GetASNumber()
+	geoLiteASN := ctx.caches.lookupGeoLiteASN(ctx.geoLiteASN, network.IP).value.AutonomousSystemNumber
+	routeViewsASN := ctx.caches.lookupRouteViewsASN(ctx.routeViewsASN, network.IP).value.AutonomousSystemNumber
+	ribOrigins := ctx.caches.lookupRIB(ctx.rib, network.IP).value.Origins
+
+	d, ok := findASNDisagreement(network, record.ASN.Number, ipinfoASN, geoLiteASN, routeViewsASN, ribOrigins)
+	if !ok {
+		return
+	}
+	record.ASN.AlternateNumbers = d.alternates()
+	ctx.disagreement = &d
+}
+
+// fillASNNames fills the organization and domain the selected ASN source left
+// empty from the names other sources know for the same ASN
+func (ctx *workerContext) fillASNNames(record *MergedRecord) {
+	if record.ASN.fillNames(ctx.asnNames) {
+		ctx.stats.asnNamesFilled++
 	}
 }
 
 // enrichWithProxyData adds proxy/anonymity information from OpenProxyDB, with
 // a bad-ASN fallback: if OpenProxyDB did not flag the IP as a proxy but the
-// ASN resolved earlier is in the bad ASN list, overlay IsProxy/IsHosting/
-// IsAnonymous onto whatever proxy record is already present.
+// ASN resolved earlier is in the bad ASN list, overlay the flags matching its
+// categories onto whatever proxy record is already present. The allowlist is
+// applied last so it can clear flags from either source, and the cloud
+// provider hosting section along with the hosting flag.
 func (ctx *workerContext) enrichWithProxyData(ip net.IP, record *MergedRecord) {
-	ctx.reusableOpenproxyRecord.Reset()
-	if ctx.openproxyDB.LookupTo(ip, &ctx.reusableOpenproxyRecord) {
+	if e := ctx.caches.lookupOpenproxyDB(ctx.openproxyDB, ip); e.found {
 		ctx.stats.openproxyDBHits++
-		record.Proxy = ProxyRecord{
-			IsProxy:     ctx.reusableOpenproxyRecord.IsProxy,
-			IsVPN:       ctx.reusableOpenproxyRecord.IsVPN,
-			IsTor:       ctx.reusableOpenproxyRecord.IsTor,
-			IsHosting:   ctx.reusableOpenproxyRecord.IsHosting,
-			IsCDN:       ctx.reusableOpenproxyRecord.IsCDN,
-			IsSchool:    ctx.reusableOpenproxyRecord.IsSchool,
-			IsAnonymous: ctx.reusableOpenproxyRecord.IsAnonymous,
+		record.Proxy = newProxyRecord(&e.value.record)
+		record.Meta.SourceNetworks.OpenProxyDB = uint8(e.value.bits)
+	}
+
+	ctx.reusableCloudRecord.Reset()
+	if ctx.cloudRanges.LookupTo(ip, &ctx.reusableCloudRecord) {
+		ctx.stats.cloudRangeHits++
+		record.applyCloudRange(&ctx.reusableCloudRecord)
+		record.Meta.SourceNetworks.Cloud = uint8(ctx.reusableCloudRecord.Bits)
+	}
+
+	if !record.Proxy.IsProxy && record.ASN.Number != 0 {
+		if listed, ok := ctx.badASN.Lookup(record.ASN.Number); ok {
+			ctx.stats.badASNHits++
+			record.Proxy.applyBadASNCategory(listed.Category)
 		}
 	}
 
-	if !record.Proxy.IsProxy && record.ASN.Number != 0 && ctx.badASN.Contains(record.ASN.Number) {
-		ctx.stats.badASNHits++
-		record.Proxy.IsProxy = true
-		record.Proxy.IsHosting = true
-		record.Proxy.IsAnonymous = true
+	if addr, ok := netipx.FromStdIP(ip); ok {
+		if flags := ctx.allowlist.Flags(addr, record.ASN.Number); flags != 0 && record.clearAllowlisted(flags) {
+			ctx.stats.allowlistHits++
+		}
+	}
+}
+
+// enrichWithConnectionType settles the connection type from the supplied
+// connection ASN list and the hosting signals gathered so far
+func (ctx *workerContext) enrichWithConnectionType(record *MergedRecord) {
+	if record.applyConnectionType(ctx.connectionASNs) {
+		ctx.stats.connectionTypeHits++
 	}
 }
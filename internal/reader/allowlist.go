@@ -0,0 +1,166 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// Allowlist suppresses proxy flags for trusted prefixes and ASNs, such as
+// office ranges or partner CDNs that upstream lists flag by mistake. Each
+// entry names the flags it clears.
+type Allowlist struct {
+	prefixes []prefixValue[ProxyFlag]
+	table    *rangeTable[ProxyFlag]
+	asns     map[uint32]ProxyFlag
+}
+
+// AllowlistPiece is one part of a prefix split along allowlist boundaries.
+// Flags is the set of flags to clear on it, 0 where nothing is allowlisted.
+type AllowlistPiece struct {
+	Prefix netip.Prefix
+	Flags  ProxyFlag
+}
+
+// newAllowlist returns an empty allowlist
+func newAllowlist() *Allowlist {
+	return &Allowlist{asns: make(map[uint32]ProxyFlag)}
+}
+
+// parseRow parses an "entry[,flags]" row where entry is a prefix, an address
+// or an ASN ("AS13335"). Flags default to all.
+func (a *Allowlist) parseRow(row []string) error {
+	if len(row) == 0 || row[0] == "" {
+		return errors.New("expected prefix|asn[,flags]")
+	}
+
+	flags := FlagAll
+	if len(row) > 1 && row[1] != "" {
+		var err error
+		if flags, err = ParseProxyFlags(row[1]); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(strings.ToUpper(row[0]), "AS") {
+		asn, ok := parseASNField(row[0])
+		if !ok {
+			return fmt.Errorf("invalid ASN %q", row[0])
+		}
+		a.asns[asn] |= flags
+		return nil
+	}
+
+	prefix, err := parsePrefixOrAddr(row[0])
+	if err != nil {
+		return err
+	}
+	a.prefixes = append(a.prefixes, prefixValue[ProxyFlag]{prefix: prefix, value: flags})
+	return nil
+}
+
+// build prepares the prefix lookup table. Nested entries accumulate: an
+// address gets the union of the flags of every entry covering it.
+func (a *Allowlist) build() {
+	entries := make([]prefixValue[ProxyFlag], len(a.prefixes))
+	for i, entry := range a.prefixes {
+		for _, outer := range a.prefixes {
+			if outer.prefix.Bits() <= entry.prefix.Bits() && outer.prefix.Contains(entry.prefix.Addr()) {
+				entry.value |= outer.value
+			}
+		}
+		entries[i] = entry
+	}
+	a.table = buildRangeTable(entries)
+}
+
+// Flags returns the flags to clear for addr, considering both the prefix
+// entries and, when asn is non-zero, the ASN entries. Safe to call on a nil
+// receiver.
+func (a *Allowlist) Flags(addr netip.Addr, asn uint32) ProxyFlag {
+	if a == nil {
+		return 0
+	}
+	var flags ProxyFlag
+	if match := a.table.lookup(addr); match != nil {
+		flags |= match.value
+	}
+	if asn != 0 {
+		flags |= a.asns[asn]
+	}
+	return flags
+}
+
+// ASNFlags returns the flags to clear for asn. Safe to call on a nil receiver.
+func (a *Allowlist) ASNFlags(asn uint32) ProxyFlag {
+	if a == nil || asn == 0 {
+		return 0
+	}
+	return a.asns[asn]
+}
+
+// HasASNs reports whether the allowlist has any ASN entries
+func (a *Allowlist) HasASNs() bool {
+	return a != nil && len(a.asns) > 0
+}
+
+// Split divides prefix along allowlist boundaries so each piece can be given
+// its own set of cleared flags. A prefix that no entry overlaps is returned
+// whole with Flags 0.
+func (a *Allowlist) Split(prefix netip.Prefix) []AllowlistPiece {
+	if a == nil || a.table.len() == 0 {
+		return []AllowlistPiece{{Prefix: prefix}}
+	}
+
+	first := prefix.Masked().Addr()
+	last := netipx.PrefixLastIP(prefix)
+	ranges := a.table.ranges
+
+	// First range that ends at or after the start of prefix
+	idx := sort.Search(len(ranges), func(i int) bool {
+		return !ranges[i].end.Less(first)
+	})
+
+	var pieces []AllowlistPiece
+	var rest netipx.IPSetBuilder
+	rest.AddPrefix(prefix)
+
+	for ; idx < len(ranges) && !last.Less(ranges[idx].start); idx++ {
+		r := ranges[idx]
+		start, end := r.start, r.end
+		if start.Less(first) {
+			start = first
+		}
+		if last.Less(end) {
+			end = last
+		}
+		overlap := netipx.IPRangeFrom(start, end)
+		rest.RemoveRange(overlap)
+		for _, p := range overlap.Prefixes() {
+			pieces = append(pieces, AllowlistPiece{Prefix: p, Flags: r.value})
+		}
+	}
+
+	if len(pieces) == 0 {
+		return []AllowlistPiece{{Prefix: prefix}}
+	}
+
+	if restSet, err := rest.IPSet(); err == nil {
+		for _, p := range restSet.Prefixes() {
+			pieces = append(pieces, AllowlistPiece{Prefix: p})
+		}
+	}
+	return pieces
+}
+
+// Count returns the number of allowlist entries
+func (a *Allowlist) Count() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.prefixes) + len(a.asns)
+}
@@ -0,0 +1,100 @@
+package reader
+
+import "fmt"
+
+// ASNName is the organization name and domain known for an AS number
+type ASNName struct {
+	Organization string
+	Domain       string
+}
+
+// ASNNames maps AS numbers to the names collected from every ASN source, so
+// a name missing from the source that won a lookup can still be filled in
+type ASNNames struct {
+	names map[uint32]ASNName
+}
+
+// asnNameRecord decodes only the fields shared by the GeoLite2-ASN and
+// RouteViews ASN databases
+type asnNameRecord struct {
+	AutonomousSystemNumber       uint32 `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// BuildASNNames walks every network of the ASN sources and records, per AS
+// number, the first non-empty organization and domain seen. Sources are
+// visited in the merge priority order, so IPinfo Lite names win, then
+// GeoLite2-ASN, then RouteViews. Only IPinfo Lite provides domains.
+func BuildASNNames(ipinfoLite *IPinfoLiteReader, geoLiteASN *GeoLite2ASNReader, routeViewsASN *RouteViewsASNReader) (*ASNNames, error) {
+	n := &ASNNames{names: make(map[uint32]ASNName)}
+
+	networks := ipinfoLite.Networks()
+	var ipinfo IPinfoLiteRecord
+	for networks.Next() {
+		ipinfo.Reset()
+		if _, err := networks.Network(&ipinfo); err != nil {
+			return nil, fmt.Errorf("failed to read IPinfo Lite network: %w", err)
+		}
+		n.add(ipinfo.GetASNumber(), ipinfo.ASName, ipinfo.ASDomain)
+	}
+	if err := networks.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate IPinfo Lite: %w", err)
+	}
+
+	for _, src := range []struct {
+		name   string
+		reader *Reader
+	}{
+		{"GeoLite2-ASN", geoLiteASN.Reader},
+		{"RouteViews ASN", routeViewsASN.Reader},
+	} {
+		networks := src.reader.Networks()
+		for networks.Next() {
+			var rec asnNameRecord
+			if _, err := networks.Network(&rec); err != nil {
+				return nil, fmt.Errorf("failed to read %s network: %w", src.name, err)
+			}
+			n.add(rec.AutonomousSystemNumber, rec.AutonomousSystemOrganization, "")
+		}
+		if err := networks.Err(); err != nil {
+			return nil, fmt.Errorf("failed to iterate %s: %w", src.name, err)
+		}
+	}
+
+	return n, nil
+}
+
+// add fills whichever of the organization and domain of asn are still empty
+func (n *ASNNames) add(asn uint32, organization, domain string) {
+	if asn == 0 || (organization == "" && domain == "") {
+		return
+	}
+	name := n.names[asn]
+	if name.Organization != "" && (name.Domain != "" || domain == "") {
+		return
+	}
+	if name.Organization == "" {
+		name.Organization = organization
+	}
+	if name.Domain == "" {
+		name.Domain = domain
+	}
+	n.names[asn] = name
+}
+
+// Lookup returns the names known for asn. Safe to call on a nil receiver.
+func (n *ASNNames) Lookup(asn uint32) (ASNName, bool) {
+	if n == nil || asn == 0 {
+		return ASNName{}, false
+	}
+	name, ok := n.names[asn]
+	return name, ok
+}
+
+// Count returns the number of AS numbers with a known name
+func (n *ASNNames) Count() int {
+	if n == nil {
+		return 0
+	}
+	return len(n.names)
+}
@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // ManuallyAddedBadASNs are ASNs treated as bad/hosting beyond those in the
@@ -17,18 +19,164 @@ import (
 // proxy-detection fallback.
 var ManuallyAddedBadASNs = []uint32{174}
 
-// BadASNReader holds the set of ASNs flagged as bad/hosting. IPs whose ASN
-// lookup resolves to an entry in this set are treated as hosting/proxy when
-// OpenProxyDB does not already mark them as a proxy.
+// BadASNCategory is a bit set describing why an ASN is listed
+type BadASNCategory uint8
+
+// Bad ASN categories
+const (
+	BadASNHosting     BadASNCategory = 1 << iota // datacenter / hosting provider
+	BadASNVPN                                    // commercial VPN provider
+	BadASNBulletproof                            // bulletproof hosting
+)
+
+// badASNCategoryNames lists the output name of every category in emission order
+var badASNCategoryNames = []struct {
+	category BadASNCategory
+	name     string
+}{
+	{BadASNHosting, "hosting"},
+	{BadASNVPN, "vpn"},
+	{BadASNBulletproof, "bulletproof"},
+}
+
+// Names returns the names of all categories in the set, in a stable order
+func (c BadASNCategory) Names() []string {
+	if c == 0 {
+		return nil
+	}
+	names := make([]string, 0, 1)
+	for _, entry := range badASNCategoryNames {
+		if c&entry.category != 0 {
+			names = append(names, entry.name)
+		}
+	}
+	return names
+}
+
+// BadASNCategoryFromName returns the category with the given output name, or
+// 0 if the name is unknown.
+func BadASNCategoryFromName(name string) BadASNCategory {
+	for _, entry := range badASNCategoryNames {
+		if entry.name == name {
+			return entry.category
+		}
+	}
+	return 0
+}
+
+// entityKeywords maps words found in the organization names of listed ASNs
+// to the category they imply, for lists without a type column. A keyword of
+// several words matches them in sequence.
+var entityKeywords = []struct {
+	keyword  string
+	category BadASNCategory
+}{
+	{"bulletproof", BadASNBulletproof},
+	{"vpn", BadASNVPN},
+	{"hosting", BadASNHosting},
+	{"datacenter", BadASNHosting},
+	{"data center", BadASNHosting},
+	{"cloud", BadASNHosting},
+	{"server", BadASNHosting},
+	{"servers", BadASNHosting},
+	{"colocation", BadASNHosting},
+	{"vps", BadASNHosting},
+}
+
+// BadASNCategoryFromEntity guesses the categories of a listed ASN from its
+// organization name ("NordVPN" is a VPN, "Rackspace Hosting" hosting).
+// Keywords only match whole words, so "Observer" is not a server; a change
+// from lower to upper case also starts a word. Returns 0 when the name
+// carries no recognized keyword.
+func BadASNCategoryFromEntity(entity string) BadASNCategory {
+	words := entityWords(entity)
+	var category BadASNCategory
+	for _, entry := range entityKeywords {
+		keyword := strings.Fields(entry.keyword)
+		for i := 0; i+len(keyword) <= len(words); i++ {
+			if slices.Equal(words[i:i+len(keyword)], keyword) {
+				category |= entry.category
+				break
+			}
+		}
+	}
+	return category
+}
+
+// entityWords splits an organization name into lower-case words at every
+// character that is not a letter or digit, and where a lower-case letter is
+// followed by an upper-case one ("ServerMania" is "server", "mania")
+func entityWords(entity string) []string {
+	var words []string
+	var word []rune
+	prevLower := false
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	for _, r := range entity {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && prevLower:
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+		prevLower = unicode.IsLower(r)
+	}
+	flush()
+	return words
+}
+
+// ParseBadASNCategory parses a free-form type field from a bad ASN list.
+// Several categories may be given separated by ';', '|' or '/'. Common
+// synonyms are recognized ("datacenter", "bph", ...). Returns 0 when nothing
+// is recognized.
+func ParseBadASNCategory(s string) BadASNCategory {
+	var category BadASNCategory
+	for _, part := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ';' || r == '|' || r == '/'
+	}) {
+		switch strings.TrimSpace(part) {
+		case "hosting", "host", "datacenter", "data center", "dc", "cloud", "webhost":
+			category |= BadASNHosting
+		case "vpn", "anonymizer":
+			category |= BadASNVPN
+		case "bulletproof", "bulletproof hosting", "bph", "abuse":
+			category |= BadASNBulletproof
+		}
+	}
+	return category
+}
+
+// BadASNEntry describes a listed ASN. A zero Category means the list gave
+// no usable type or entity name for it.
+type BadASNEntry struct {
+	Category BadASNCategory
+	Entity   string // Organization name as given by the list, if any
+}
+
+// BadASNReader holds the ASNs flagged as bad along with their categories.
+// IPs whose ASN lookup resolves to an entry are given the proxy flags that
+// match the entry's categories, or the proxy, hosting and anonymous flags for
+// an uncategorized entry, when OpenProxyDB does not already mark them as a
+// proxy.
 type BadASNReader struct {
-	asns map[uint32]struct{}
+	asns map[uint32]BadASNEntry
 }
 
 // OpenBadASNList opens and parses the bad-asn-list CSV file at path. The file
 // is expected to have a header row identifying an "ASN" column; if no such
-// header exists the first column is used. Additional ASNs from
-// ManuallyAddedBadASNs are merged into the set regardless of what the file
-// contains.
+// header exists the first column is used. Optional "Entity" and "Type"
+// columns supply the organization name and category. The upstream list has
+// no type column, so entries without a recognized type take their category
+// from the entity name and stay uncategorized when it names none. Additional
+// ASNs from ManuallyAddedBadASNs are merged into the set
+// as hosting regardless of what the file contains.
 func OpenBadASNList(path string) (*BadASNReader, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -37,7 +185,7 @@ func OpenBadASNList(path string) (*BadASNReader, error) {
 	defer file.Close()
 
 	r := &BadASNReader{
-		asns: make(map[uint32]struct{}),
+		asns: make(map[uint32]BadASNEntry),
 	}
 
 	buffered := bufio.NewReaderSize(file, 64*1024)
@@ -56,13 +204,14 @@ func OpenBadASNList(path string) (*BadASNReader, error) {
 	}
 
 	asnCol := findASNColumn(header)
-
-	// If the first row doesn't look like a header (first field is numeric),
-	// treat it as a data row.
-	if !looksLikeHeader(header) && asnCol < len(header) {
-		if asn, ok := parseASNField(header[asnCol]); ok {
-			r.asns[asn] = struct{}{}
-		}
+	entityCol, typeCol := -1, -1
+	if looksLikeHeader(header) {
+		entityCol = findNamedColumn(header, "entity", "name", "organization", "org")
+		typeCol = findNamedColumn(header, "type", "category", "kind")
+	} else {
+		// The first row doesn't look like a header (first field is numeric),
+		// treat it as a data row.
+		r.addRow(header, asnCol, entityCol, typeCol)
 	}
 
 	for {
@@ -78,22 +227,54 @@ func OpenBadASNList(path string) (*BadASNReader, error) {
 			}
 			return nil, fmt.Errorf("failed to read bad ASN list: %w", err)
 		}
-		if asnCol >= len(row) {
-			continue
-		}
-		if asn, ok := parseASNField(row[asnCol]); ok {
-			r.asns[asn] = struct{}{}
-		}
+		r.addRow(row, asnCol, entityCol, typeCol)
 	}
 
 	r.addManual()
 	return r, nil
 }
 
+// addRow adds the ASN in row, if any. A negative column index means the
+// column is absent.
+func (r *BadASNReader) addRow(row []string, asnCol, entityCol, typeCol int) {
+	if asnCol >= len(row) {
+		return
+	}
+	asn, ok := parseASNField(row[asnCol])
+	if !ok {
+		return
+	}
+
+	var entry BadASNEntry
+	if entityCol >= 0 && entityCol < len(row) {
+		entry.Entity = strings.TrimSpace(row[entityCol])
+	}
+	if typeCol >= 0 && typeCol < len(row) {
+		entry.Category = ParseBadASNCategory(row[typeCol])
+	}
+	r.Add(asn, entry)
+}
+
 func (r *BadASNReader) addManual() {
 	for _, asn := range ManuallyAddedBadASNs {
-		r.asns[asn] = struct{}{}
+		r.Add(asn, BadASNEntry{Category: BadASNHosting})
+	}
+}
+
+// Add lists asn with the given entry. A zero category is derived from the
+// entity name with BadASNCategoryFromEntity. If the ASN is already listed the
+// categories are combined and a non-empty entity replaces the previous one.
+func (r *BadASNReader) Add(asn uint32, entry BadASNEntry) {
+	if entry.Category == 0 {
+		entry.Category = BadASNCategoryFromEntity(entry.Entity)
+	}
+	if existing, ok := r.asns[asn]; ok {
+		entry.Category |= existing.Category
+		if entry.Entity == "" {
+			entry.Entity = existing.Entity
+		}
 	}
+	r.asns[asn] = entry
 }
 
 // looksLikeHeader returns true when the row is likely a header — i.e. the
@@ -109,14 +290,25 @@ func looksLikeHeader(row []string) bool {
 // findASNColumn returns the index of the column named "asn" (case-insensitive)
 // in a header row; if no such column is found it returns 0 (first column).
 func findASNColumn(header []string) int {
-	for i, col := range header {
-		if strings.EqualFold(strings.TrimSpace(col), "asn") {
-			return i
-		}
+	if i := findNamedColumn(header, "asn"); i >= 0 {
+		return i
 	}
 	return 0
 }
 
+// findNamedColumn returns the index of the first header column matching any
+// of names (case-insensitive), or -1 if none does.
+func findNamedColumn(header []string, names ...string) int {
+	for _, name := range names {
+		for i, col := range header {
+			if strings.EqualFold(strings.TrimSpace(col), name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 // parseASNField parses a raw CSV field into an ASN number, stripping an
 // optional "AS" prefix and surrounding whitespace.
 func parseASNField(s string) (uint32, bool) {
@@ -150,6 +342,31 @@ func (r *BadASNReader) Contains(asn uint32) bool {
 	return ok
 }
 
+// Remove unlists asn
+func (r *BadASNReader) Remove(asn uint32) {
+	delete(r.asns, asn)
+}
+
+// Lookup returns the list entry for asn and whether it is listed
+func (r *BadASNReader) Lookup(asn uint32) (BadASNEntry, bool) {
+	if r == nil || asn == 0 {
+		return BadASNEntry{}, false
+	}
+	entry, ok := r.asns[asn]
+	return entry, ok
+}
+
+// ForEach calls fn for every listed ASN, in no particular order. Safe to
+// call on a nil receiver.
+func (r *BadASNReader) ForEach(fn func(asn uint32, entry BadASNEntry)) {
+	if r == nil {
+		return
+	}
+	for asn, entry := range r.asns {
+		fn(asn, entry)
+	}
+}
+
 // Count returns the number of bad ASNs loaded, including manually added
 // entries. Returns 0 for a nil receiver.
 func (r *BadASNReader) Count() int {
@@ -0,0 +1,40 @@
+package reader
+
+import "testing"
+
+func TestBadASNCategoryFromEntity(t *testing.T) {
+	// Organization names in the form the bad-asn-list Entity column gives
+	// them, and names where a keyword is only part of a longer word
+	tests := []struct {
+		entity string
+		want   BadASNCategory
+	}{
+		{"Rackspace Hosting", BadASNHosting},
+		{"ServerMania Inc.", BadASNHosting},
+		{"Total Server Solutions L.L.C.", BadASNHosting},
+		{"Psychz Networks", 0},
+		{"Hetzner Online GmbH", 0},
+		{"DigitalOcean, LLC", 0},
+		{"OVH SAS", 0},
+		{"Choopa, LLC", 0},
+		{"M247 Ltd", 0},
+		{"HostDime.com, Inc.", 0},
+		{"Google Cloud", BadASNHosting},
+		{"Data Center Solutions Ltd", BadASNHosting},
+		{"DataCenter Corp", BadASNHosting},
+		{"NordVPN", BadASNVPN},
+		{"Private Layer VPN Hosting", BadASNVPN | BadASNHosting},
+		{"Bulletproof Servers LLC", BadASNBulletproof | BadASNHosting},
+		{"Observer Networks", 0},
+		{"Cloudflare, Inc.", 0},
+		{"Reserved VPNet Corp", 0},
+		{"Serverless Inc", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := BadASNCategoryFromEntity(tt.entity); got != tt.want {
+			t.Errorf("BadASNCategoryFromEntity(%q) = %v, want %v", tt.entity, got.Names(), tt.want.Names())
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"merged-ip-data/internal/config"
+)
+
+// Cloud provider names emitted as hosting.provider
+const (
+	CloudProviderAWS        = "aws"
+	CloudProviderGCP        = "gcp"
+	CloudProviderAzure      = "azure"
+	CloudProviderOracle     = "oracle"
+	CloudProviderCloudflare = "cloudflare"
+)
+
+// CloudRangeRecord identifies the cloud provider, and where published the
+// service and region, owning an IP range
+type CloudRangeRecord struct {
+	Provider string
+	Service  string
+	Region   string
+	Bits     int // length of the published prefix containing the address; set by LookupTo
+}
+
+// CloudRangesReader holds the IP ranges published by the major cloud
+// providers. All input files are optional; a provider whose file is missing
+// simply contributes no ranges.
+type CloudRangesReader struct {
+	ranges []prefixValue[CloudRangeRecord]
+	table  *rangeTable[CloudRangeRecord]
+
+	// providerCounts is the number of ranges loaded per provider
+	providerCounts map[string]int
+}
+
+// cloudRangeFile pairs a published range file with its parser
+type cloudRangeFile struct {
+	provider string
+	path     string
+	parse    func(file *os.File, add func(prefix string, rec CloudRangeRecord)) error
+}
+
+// OpenCloudRanges loads every cloud provider range file present on disk
+func OpenCloudRanges() (*CloudRangesReader, error) {
+	files := []cloudRangeFile{
+		{CloudProviderAWS, config.AWSIPRangesFile, parseAWSRanges},
+		{CloudProviderGCP, config.GCPCloudRangesFile, parseGCPRanges},
+		{CloudProviderAzure, config.AzureServiceTagsFile, parseAzureServiceTags},
+		{CloudProviderOracle, config.OracleIPRangesFile, parseOracleRanges},
+		{CloudProviderCloudflare, config.CloudflareV4File, parsePlainRanges},
+		{CloudProviderCloudflare, config.CloudflareV6File, parsePlainRanges},
+	}
+
+	r := &CloudRangesReader{providerCounts: make(map[string]int)}
+
+	// The same prefix is often published several times, e.g. by AWS once
+	// as the generic AMAZON service and once as EC2; keep the most
+	// descriptive entry
+	byPrefix := make(map[netip.Prefix]CloudRangeRecord)
+
+	for _, f := range files {
+		file, err := os.Open(f.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to open %s ranges: %w", f.provider, err)
+		}
+
+		err = f.parse(file, func(s string, rec CloudRangeRecord) {
+			prefix, ok := parseFeedEntry(s)
+			if !ok {
+				return
+			}
+			rec.Provider = f.provider
+			if existing, ok := byPrefix[prefix]; ok && !rec.moreSpecificThan(&existing) {
+				return
+			}
+			byPrefix[prefix] = rec
+		})
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s ranges: %w", f.provider, err)
+		}
+	}
+
+	r.ranges = make([]prefixValue[CloudRangeRecord], 0, len(byPrefix))
+	for prefix, rec := range byPrefix {
+		r.ranges = append(r.ranges, prefixValue[CloudRangeRecord]{prefix: prefix, value: rec})
+		r.providerCounts[rec.Provider]++
+	}
+	r.table = buildRangeTable(r.ranges)
+
+	return r, nil
+}
+
+// moreSpecificThan reports whether r describes its range better than other:
+// a named service wins over none, then a region over none
+func (r *CloudRangeRecord) moreSpecificThan(other *CloudRangeRecord) bool {
+	if (r.Service != "") != (other.Service != "") {
+		return r.Service != ""
+	}
+	return r.Region != "" && other.Region == ""
+}
+
+// LookupTo looks up an IP address into a pre-allocated record.
+// Returns true if the address belongs to a published cloud range.
+func (r *CloudRangesReader) LookupTo(ip net.IP, record *CloudRangeRecord) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	match := r.table.lookup(addr.Unmap())
+	if match == nil {
+		return false
+	}
+	*record = match.value
+	record.Bits = match.bits
+	return true
+}
+
+// ForEach calls fn for every loaded range in address order, least specific
+// first
+func (r *CloudRangesReader) ForEach(fn func(prefix netip.Prefix, record CloudRangeRecord)) {
+	for i := range r.ranges {
+		fn(r.ranges[i].prefix, r.ranges[i].value)
+	}
+}
+
+// ProviderCounts returns the number of ranges loaded per provider
+func (r *CloudRangesReader) ProviderCounts() map[string]int {
+	return r.providerCounts
+}
+
+// Count returns the total number of ranges loaded
+func (r *CloudRangesReader) Count() int {
+	return len(r.ranges)
+}
+
+// Close closes the reader (no-op as data is in memory)
+func (r *CloudRangesReader) Close() error {
+	return nil
+}
+
+// HasData checks if the record names a provider
+func (r *CloudRangeRecord) HasData() bool {
+	return r.Provider != ""
+}
+
+// Reset clears all fields for reuse
+func (r *CloudRangeRecord) Reset() {
+	r.Provider = ""
+	r.Service = ""
+	r.Region = ""
+	r.Bits = 0
+}
+
+// cloudRegion drops the placeholder region names some providers publish for
+// ranges that are not tied to a region
+func cloudRegion(region string) string {
+	switch strings.ToLower(region) {
+	case "global", "glb":
+		return ""
+	}
+	return region
+}
+
+// parseAWSRanges parses ip-ranges.json as published by AWS
+func parseAWSRanges(file *os.File, add func(prefix string, rec CloudRangeRecord)) error {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+			Region   string `json:"region"`
+			Service  string `json:"service"`
+		} `json:"prefixes"`
+		IPv6Prefixes []struct {
+			IPv6Prefix string `json:"ipv6_prefix"`
+			Region     string `json:"region"`
+			Service    string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&doc); err != nil {
+		return err
+	}
+
+	// AMAZON is the catch-all service covering every other one
+	awsService := func(service string) string {
+		if service == "AMAZON" {
+			return ""
+		}
+		return service
+	}
+	for _, p := range doc.Prefixes {
+		add(p.IPPrefix, CloudRangeRecord{Service: awsService(p.Service), Region: cloudRegion(p.Region)})
+	}
+	for _, p := range doc.IPv6Prefixes {
+		add(p.IPv6Prefix, CloudRangeRecord{Service: awsService(p.Service), Region: cloudRegion(p.Region)})
+	}
+	return nil
+}
+
+// parseGCPRanges parses cloud.json as published by Google Cloud
+func parseGCPRanges(file *os.File, add func(prefix string, rec CloudRangeRecord)) error {
+	var doc struct {
+		Prefixes []struct {
+			IPv4Prefix string `json:"ipv4Prefix"`
+			IPv6Prefix string `json:"ipv6Prefix"`
+			Service    string `json:"service"`
+			Scope      string `json:"scope"`
+		} `json:"prefixes"`
+	}
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&doc); err != nil {
+		return err
+	}
+
+	for _, p := range doc.Prefixes {
+		rec := CloudRangeRecord{Service: p.Service, Region: cloudRegion(p.Scope)}
+		if p.IPv4Prefix != "" {
+			add(p.IPv4Prefix, rec)
+		}
+		if p.IPv6Prefix != "" {
+			add(p.IPv6Prefix, rec)
+		}
+	}
+	return nil
+}
+
+// parseAzureServiceTags parses the weekly ServiceTags_Public JSON file as
+// published by Microsoft
+func parseAzureServiceTags(file *os.File, add func(prefix string, rec CloudRangeRecord)) error {
+	var doc struct {
+		Values []struct {
+			Properties struct {
+				Region          string   `json:"region"`
+				SystemService   string   `json:"systemService"`
+				AddressPrefixes []string `json:"addressPrefixes"`
+			} `json:"properties"`
+		} `json:"values"`
+	}
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&doc); err != nil {
+		return err
+	}
+
+	for _, v := range doc.Values {
+		rec := CloudRangeRecord{
+			Service: v.Properties.SystemService,
+			Region:  cloudRegion(v.Properties.Region),
+		}
+		for _, prefix := range v.Properties.AddressPrefixes {
+			add(prefix, rec)
+		}
+	}
+	return nil
+}
+
+// parseOracleRanges parses public_ip_ranges.json as published by Oracle Cloud
+func parseOracleRanges(file *os.File, add func(prefix string, rec CloudRangeRecord)) error {
+	var doc struct {
+		Regions []struct {
+			Region string `json:"region"`
+			CIDRs  []struct {
+				CIDR string   `json:"cidr"`
+				Tags []string `json:"tags"`
+			} `json:"cidrs"`
+		} `json:"regions"`
+	}
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&doc); err != nil {
+		return err
+	}
+
+	for _, region := range doc.Regions {
+		for _, c := range region.CIDRs {
+			rec := CloudRangeRecord{Region: cloudRegion(region.Region)}
+			if len(c.Tags) > 0 {
+				rec.Service = c.Tags[0]
+			}
+			add(c.CIDR, rec)
+		}
+	}
+	return nil
+}
+
+// parsePlainRanges parses a plain list with one prefix per line, as
+// published by Cloudflare
+func parsePlainRanges(file *os.File, add func(prefix string, rec CloudRangeRecord)) error {
+	return readFeedLines(file, func(value, _ string) {
+		add(value, CloudRangeRecord{})
+	})
+}
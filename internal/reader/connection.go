@@ -0,0 +1,134 @@
+package reader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Connection types emitted as connection.connection_type
+const (
+	ConnectionCellular  = "cellular"
+	ConnectionCableDSL  = "cable/dsl"
+	ConnectionCorporate = "corporate"
+	ConnectionHosting   = "hosting"
+	ConnectionSatellite = "satellite"
+)
+
+// connectionTypeNames maps accepted spellings to connection types
+var connectionTypeNames = map[string]string{
+	"cellular":  ConnectionCellular,
+	"mobile":    ConnectionCellular,
+	"cable/dsl": ConnectionCableDSL,
+	"cable":     ConnectionCableDSL,
+	"dsl":       ConnectionCableDSL,
+	"fixed":     ConnectionCableDSL,
+	"corporate": ConnectionCorporate,
+	"hosting":   ConnectionHosting,
+	"satellite": ConnectionSatellite,
+}
+
+// ConnectionASNs maps ASNs to the connection type of their networks, as
+// supplied in a local list of mobile carrier, satellite and other ASNs
+type ConnectionASNs struct {
+	asns map[uint32]string
+}
+
+// LoadConnectionASNs reads the optional connection ASN list at path: CSV
+// without a header, '#' comments, one "asn[,connection_type]" row per ASN.
+// The type defaults to cellular, so a plain list of mobile carrier ASNs
+// works as is. A missing file yields an empty list.
+func LoadConnectionASNs(path string) (*ConnectionASNs, error) {
+	c := &ConnectionASNs{asns: make(map[uint32]string)}
+	err := readLocalCSV(path, func(row []string) error {
+		asn, ok := parseASNField(row[0])
+		if !ok {
+			return fmt.Errorf("invalid ASN %q", row[0])
+		}
+		connType := ConnectionCellular
+		if len(row) > 1 && row[1] != "" {
+			if connType, ok = connectionTypeNames[strings.ToLower(row[1])]; !ok {
+				return fmt.Errorf("unknown connection type %q", row[1])
+			}
+		}
+		c.asns[asn] = connType
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Lookup returns the connection type listed for asn, or "". Safe to call on
+// a nil receiver.
+func (c *ConnectionASNs) Lookup(asn uint32) string {
+	if c == nil || asn == 0 {
+		return ""
+	}
+	return c.asns[asn]
+}
+
+// Count returns the number of ASNs listed
+func (c *ConnectionASNs) Count() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.asns)
+}
+
+// qqwryISPKeywords classifies Chinese ISP strings, checked in order. Mobile
+// data egress ranges are labelled explicitly ("移动数据上网公共出口",
+// "联通3G/4G/5G网络"); a bare carrier name also covers fixed broadband, so
+// it only implies cable/DSL. Cloud providers are matched by name rather
+// than by a bare "云", which also starts 云南 (Yunnan). A company name alone
+// says nothing about the network, so it is left unclassified.
+var qqwryISPKeywords = []struct {
+	keyword  string
+	connType string
+}{
+	{"数据上网", ConnectionCellular},
+	{"移动网络", ConnectionCellular},
+	{"基站", ConnectionCellular},
+	{"3G", ConnectionCellular},
+	{"4G", ConnectionCellular},
+	{"5G", ConnectionCellular},
+	{"卫星", ConnectionSatellite},
+	{"云计算", ConnectionHosting},
+	{"云服务", ConnectionHosting},
+	{"阿里云", ConnectionHosting},
+	{"腾讯云", ConnectionHosting},
+	{"华为云", ConnectionHosting},
+	{"百度云", ConnectionHosting},
+	{"天翼云", ConnectionHosting},
+	{"移动云", ConnectionHosting},
+	{"联通云", ConnectionHosting},
+	{"金山云", ConnectionHosting},
+	{"IDC", ConnectionHosting},
+	{"数据中心", ConnectionHosting},
+	{"机房", ConnectionHosting},
+	{"教育网", ConnectionCorporate},
+	{"大学", ConnectionCorporate},
+	{"学院", ConnectionCorporate},
+	{"电信", ConnectionCableDSL},
+	{"联通", ConnectionCableDSL},
+	{"移动", ConnectionCableDSL},
+	{"铁通", ConnectionCableDSL},
+	{"广电", ConnectionCableDSL},
+	{"宽带", ConnectionCableDSL},
+	{"长城", ConnectionCableDSL},
+}
+
+// ConnectionType derives the connection type from the ISP string of a
+// Chinese record, or returns "" if it names no known kind of network
+func (r *QQWryRecord) ConnectionType() string {
+	isp := r.ISPDomain
+	if isp == "" {
+		return ""
+	}
+	for _, k := range qqwryISPKeywords {
+		if strings.Contains(isp, k.keyword) {
+			return k.connType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package reader
+
+import "testing"
+
+func TestQQWryConnectionType(t *testing.T) {
+	tests := []struct {
+		isp  string
+		want string
+	}{
+		{"电信", ConnectionCableDSL},
+		{"云南电信", ConnectionCableDSL},
+		{"云南联通", ConnectionCableDSL},
+		{"移动", ConnectionCableDSL},
+		{"移动数据上网公共出口", ConnectionCellular},
+		{"联通3G网络", ConnectionCellular},
+		{"电信5G基站", ConnectionCellular},
+		{"阿里云", ConnectionHosting},
+		{"腾讯云", ConnectionHosting},
+		{"阿里云计算有限公司", ConnectionHosting},
+		{"天翼云数据中心", ConnectionHosting},
+		{"电信IDC机房", ConnectionHosting},
+		{"移动云", ConnectionHosting},
+		{"教育网", ConnectionCorporate},
+		{"清华大学", ConnectionCorporate},
+		{"北京某某科技有限公司", ""},
+		{"CZ88.NET", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		r := QQWryRecord{ISPDomain: tt.isp}
+		if got := r.ConnectionType(); got != tt.want {
+			t.Errorf("ConnectionType(%q) = %q, want %q", tt.isp, got, tt.want)
+		}
+	}
+}
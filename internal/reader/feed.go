@@ -0,0 +1,528 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"merged-ip-data/internal/config"
+
+	"go4.org/netipx"
+)
+
+// Flag feed formats
+const (
+	FeedFormatIP   = "ip"
+	FeedFormatCIDR = "cidr"
+	FeedFormatCSV  = "csv"
+	FeedFormatJSON = "json"
+)
+
+// feedOverlay holds the prefixes of one flag feed. Like the anycast list it
+// replaces, it is an overlay: its flags are OR'd onto whatever OpenProxyDB
+// reports for a contained address instead of competing with the CIDR ranges
+// for the most specific match.
+type feedOverlay struct {
+	name    string
+	record  OpenproxyDBRecord
+	builder *netipx.IPSetBuilder
+	set     *netipx.IPSet
+
+	// spans is set as a range table, used to find how far around an
+	// address the overlay gives the same answer
+	spans *rangeTable[struct{}]
+
+	// listed are the feed's prefixes as listed, before coalescing, each
+	// once; index finds the entry of a prefix while loading
+	listed []prefixValue[feedDetail]
+	index  map[netip.Prefix]int
+
+	// details is listed as a range table, built when the feed has seen
+	// dates or its operators are resolved with AnnotateFeedOperators
+	details *rangeTable[feedDetail]
+}
+
+// feedDetail is what a feed says about one listed prefix beyond its flags
+type feedDetail struct {
+	operator  string
+	firstSeen string
+	lastSeen  string
+}
+
+// FeedStats reports what a single feed added
+type FeedStats struct {
+	SingleIPs int // addresses merged into the single IP map
+	Prefixes  int // prefixes added to the feed overlay
+	Expired   int // entries dropped because they were older than the expiry
+	Rejected  int // prefixes listed in an "ip" feed
+}
+
+// LoadFeeds reads the flag feeds in order, calling loaded after each one,
+// and returns the proxy sources naming them: the built-in sources plus one
+// per feed name, weighted as configured. Bare addresses are merged into the
+// single IP map, inheriting the flags of any covering CIDR range; prefixes
+// are collected into the feed's overlay. Feeds sharing a name share one
+// source and one overlay. Call BuildFeedOverlays once it returns.
+func (r *OpenproxyDBReader) LoadFeeds(feeds []config.FeedSource, loaded func(feed config.FeedSource, stats FeedStats)) (*ProxySources, error) {
+	sources := NewProxySources()
+	for _, feed := range feeds {
+		stats, err := r.loadFeed(feed, sources)
+		if err != nil {
+			return nil, err
+		}
+		if loaded != nil {
+			loaded(feed, stats)
+		}
+	}
+	return sources, nil
+}
+
+// loadFeed reads one flag feed, registering its source in sources
+func (r *OpenproxyDBReader) loadFeed(feed config.FeedSource, sources *ProxySources) (FeedStats, error) {
+	var stats FeedStats
+
+	flags, err := ParseProxyFlags(feed.Flags)
+	if err != nil {
+		return stats, fmt.Errorf("feed %s: %w", feed.Name, err)
+	}
+	if flags == 0 {
+		return stats, fmt.Errorf("feed %s: no flags given", feed.Name)
+	}
+	source, err := sources.register(feed.Name, feed.Weight)
+	if err != nil {
+		return stats, fmt.Errorf("feed %s: %w", feed.Name, err)
+	}
+
+	var template OpenproxyDBRecord
+	template.SetFlags(flags)
+	template.Sources = source
+
+	// Expiry needs a date per entry: the file's own mtime is refreshed by
+	// every download, so it says nothing about the age of the entries
+	var expiry time.Duration
+	if feed.Expiry != "" {
+		if feed.DateField == "" {
+			return stats, fmt.Errorf("feed %s: expiry requires date_field", feed.Name)
+		}
+		if expiry, err = parseExpiry(feed.Expiry); err != nil {
+			return stats, fmt.Errorf("feed %s: %w", feed.Name, err)
+		}
+	}
+	cutoff := time.Now().Add(-expiry).UTC().Format(seenDateLayout)
+
+	file, err := os.Open(feed.Path)
+	if err != nil {
+		return stats, fmt.Errorf("failed to open feed %s: %w", feed.Name, err)
+	}
+	defer file.Close()
+
+	overlay := r.feedOverlay(feed.Name, template)
+
+	add := func(value, date string) {
+		prefix, ok := parseFeedEntry(value)
+		if !ok {
+			return
+		}
+		if feed.Format == FeedFormatIP && !prefix.IsSingleIP() {
+			stats.Rejected++
+			return
+		}
+		seen := ""
+		if feed.DateField != "" {
+			seen = parseSeenDate(date)
+			if expiry > 0 && (seen == "" || seen < cutoff) {
+				stats.Expired++
+				return
+			}
+		}
+
+		if prefix.IsSingleIP() {
+			r.mergeSingleIP(prefix.Addr(), &template, seen)
+			stats.SingleIPs++
+			return
+		}
+		overlay.add(prefix, seen)
+		stats.Prefixes++
+	}
+
+	switch feed.Format {
+	case "", FeedFormatIP, FeedFormatCIDR:
+		err = readFeedLines(file, add)
+	case FeedFormatCSV:
+		err = readFeedCSV(file, feed.Field, feed.DateField, add)
+	case FeedFormatJSON:
+		err = readFeedJSON(file, feed.JSONPath, feed.Field, feed.DateField, add)
+	default:
+		err = fmt.Errorf("unknown format %q", feed.Format)
+	}
+	if err != nil {
+		return stats, fmt.Errorf("failed to read feed %s: %w", feed.Name, err)
+	}
+
+	return stats, nil
+}
+
+// feedOverlay returns the pending overlay for the named feed, creating it
+func (r *OpenproxyDBReader) feedOverlay(name string, record OpenproxyDBRecord) *feedOverlay {
+	for _, overlay := range r.overlays {
+		if overlay.name == name {
+			return overlay
+		}
+	}
+	overlay := &feedOverlay{
+		name:    name,
+		record:  record,
+		builder: &netipx.IPSetBuilder{},
+		index:   make(map[netip.Prefix]int),
+	}
+	r.overlays = append(r.overlays, overlay)
+	return overlay
+}
+
+// add lists prefix, seen on the given date. A prefix listed more than once
+// keeps the widest first/last-seen window, as single IPs do.
+func (o *feedOverlay) add(prefix netip.Prefix, seen string) {
+	o.builder.AddPrefix(prefix)
+	if i, ok := o.index[prefix]; ok {
+		detail := &o.listed[i].value
+		detail.firstSeen = EarlierDate(detail.firstSeen, seen)
+		detail.lastSeen = LaterDate(detail.lastSeen, seen)
+		return
+	}
+	o.index[prefix] = len(o.listed)
+	o.listed = append(o.listed, prefixValue[feedDetail]{
+		prefix: prefix,
+		value:  feedDetail{firstSeen: seen, lastSeen: seen},
+	})
+}
+
+// applyTo ORs the overlay's flags onto the record of addr, a contained
+// address, along with the seen dates and operator of its listed prefix
+func (o *feedOverlay) applyTo(addr netip.Addr, record *OpenproxyDBRecord) {
+	record.inheritFlags(&o.record)
+	if match := o.details.lookup(addr); match != nil {
+		record.observe(match.value.firstSeen, match.value.lastSeen)
+		if record.CDNProvider == "" {
+			record.CDNProvider = match.value.operator
+		}
+	}
+}
+
+// mergeSingleIP ORs the flags of template onto the single IP entry for addr,
+// creating the entry (with the flags of any covering CIDR range) if needed
+func (r *OpenproxyDBReader) mergeSingleIP(addr netip.Addr, template *OpenproxyDBRecord, seen string) {
+	rec, found := r.singleIPs[addr]
+	if !found {
+		// Inherit any CIDR-level flags covering this IP (e.g. Hosting)
+		// so they coexist with the feed flags on the /32 record.
+		if cidr, ok := r.findInCIDR(addr); ok {
+			rec = cidr
+		}
+	}
+	rec.inheritFlags(template)
+	rec.observe(seen, seen)
+	r.singleIPs[addr] = rec
+}
+
+// BuildFeedOverlays builds the lookup sets of every feed overlay and ORs
+// their flags and seen dates onto the single IP entries they contain, so the
+// merger's direct /32 and /128 insertion path carries them too. CIDR-level
+// lookups pick the overlays up in LookupTo. Returns the number of prefixes
+// in the lookup sets.
+func (r *OpenproxyDBReader) BuildFeedOverlays() (int, error) {
+	total := 0
+	var built []*feedOverlay
+	for _, overlay := range r.overlays {
+		set, err := overlay.builder.IPSet()
+		if err != nil {
+			return total, fmt.Errorf("failed to build %s prefix set: %w", overlay.name, err)
+		}
+		overlay.builder = nil
+		overlay.index = nil
+		if len(set.Prefixes()) == 0 {
+			continue
+		}
+		overlay.set = set
+		built = append(built, overlay)
+		total += len(set.Prefixes())
+
+		entries := make([]prefixValue[struct{}], 0, len(set.Prefixes()))
+		for _, prefix := range set.Prefixes() {
+			entries = append(entries, prefixValue[struct{}]{prefix: prefix})
+		}
+		overlay.spans = buildRangeTable(entries)
+
+		if slices.ContainsFunc(overlay.listed, func(e prefixValue[feedDetail]) bool {
+			return e.value.firstSeen != ""
+		}) {
+			overlay.details = buildRangeTable(overlay.listed)
+		}
+	}
+	r.overlays = built
+
+	for addr, rec := range r.singleIPs {
+		changed := false
+		for _, overlay := range r.overlays {
+			if overlay.set.Contains(addr) {
+				overlay.applyTo(addr, &rec)
+				changed = true
+			}
+		}
+		if changed {
+			r.singleIPs[addr] = rec
+		}
+	}
+
+	return total, nil
+}
+
+// AnnotateFeedOperators resolves the organization announcing every listed
+// prefix of the named feed, e.g. the operator of an anycast prefix, and
+// records it as CDNProvider on the addresses the feed covers. Call it after
+// BuildFeedOverlays. Returns the number of prefixes resolved.
+func (r *OpenproxyDBReader) AnnotateFeedOperators(name string, resolve func(prefix netip.Prefix) string) int {
+	for _, overlay := range r.overlays {
+		if overlay.name != name {
+			continue
+		}
+
+		// Unresolved prefixes keep an empty operator so the table still
+		// covers the whole feed for ForEachFeedPrefix
+		resolved := 0
+		for i := range overlay.listed {
+			operator := resolve(overlay.listed[i].prefix)
+			if operator != "" {
+				resolved++
+			}
+			overlay.listed[i].value.operator = operator
+		}
+		overlay.details = buildRangeTable(overlay.listed)
+
+		for addr, rec := range r.singleIPs {
+			if rec.CDNProvider != "" {
+				continue
+			}
+			if match := overlay.details.lookup(addr); match != nil && match.value.operator != "" {
+				rec.CDNProvider = match.value.operator
+				r.singleIPs[addr] = rec
+			}
+		}
+		return resolved
+	}
+	return 0
+}
+
+// ForEachFeedPrefix calls fn for every prefix of every feed overlay, with
+// the flags the feed sets. Feeds with seen dates or resolved operators are
+// visited per listed range so each prefix carries its dates and CDNProvider.
+func (r *OpenproxyDBReader) ForEachFeedPrefix(fn func(prefix netip.Prefix, record OpenproxyDBRecord)) {
+	for _, overlay := range r.overlays {
+		if overlay.set == nil {
+			continue
+		}
+		if overlay.details == nil {
+			for _, prefix := range overlay.set.Prefixes() {
+				fn(prefix, overlay.record)
+			}
+			continue
+		}
+		for _, vr := range overlay.details.ranges {
+			record := overlay.record
+			record.CDNProvider = vr.value.operator
+			record.observe(vr.value.firstSeen, vr.value.lastSeen)
+			for _, prefix := range netipx.IPRangeFrom(vr.start, vr.end).Prefixes() {
+				fn(prefix, record)
+			}
+		}
+	}
+}
+
+// parseFeedEntry parses a prefix or a bare address, returning a /32 or /128
+// for the latter. IPv4-mapped IPv6 addresses are unmapped.
+func parseFeedEntry(s string) (netip.Prefix, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Prefix{}, false
+	}
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), true
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// parseExpiry parses a Go duration, additionally accepting whole days ("30d")
+func parseExpiry(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid expiry %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid expiry %q", s)
+	}
+	return d, nil
+}
+
+// readFeedLines reads a plain list with one entry per line. Blank lines and
+// comments starting with '#' or ';' are skipped, and only the first field of
+// a line is used, so "192.0.2.0/24 ; SBL123" yields the prefix.
+func readFeedLines(file io.Reader, add func(value, date string)) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		add(fields[0], "")
+	}
+
+	return scanner.Err()
+}
+
+// readFeedCSV reads the entry (and optionally the date) column of a CSV
+// feed. A column given by name requires a header row; one given by index
+// does not, and a header row is then skipped as an unparseable entry.
+func readFeedCSV(file io.Reader, field, dateField string, add func(value, date string)) error {
+	csvReader := csv.NewReader(bufio.NewReader(file))
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comment = '#'
+	csvReader.ReuseRecord = true
+
+	valueIdx, valueByName := csvColumnIndex(field)
+	dateIdx, dateByName := csvColumnIndex(dateField)
+	if dateField == "" {
+		dateIdx = -1
+	}
+
+	if valueByName || dateByName {
+		header, err := csvReader.Read()
+		if err != nil {
+			return fmt.Errorf("failed to read CSV header: %w", err)
+		}
+		if valueByName {
+			if valueIdx = findNamedColumn(header, field); valueIdx < 0 {
+				return fmt.Errorf("missing column %q", field)
+			}
+		}
+		if dateByName {
+			if dateIdx = findNamedColumn(header, dateField); dateIdx < 0 {
+				return fmt.Errorf("missing column %q", dateField)
+			}
+		}
+	}
+
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if valueIdx >= len(row) {
+			continue
+		}
+		date := ""
+		if dateIdx >= 0 && dateIdx < len(row) {
+			date = row[dateIdx]
+		}
+		add(row[valueIdx], date)
+	}
+}
+
+// csvColumnIndex interprets a column selector. An empty selector is column
+// 0; a number is a 0-based index; anything else is a header name.
+func csvColumnIndex(field string) (idx int, byName bool) {
+	if field == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(field); err == nil && n >= 0 {
+		return n, false
+	}
+	return -1, true
+}
+
+// readFeedJSON walks path through a JSON document and adds every entry
+// found there. Entries are strings, or objects whose field key holds the
+// entry (and dateField key the date).
+func readFeedJSON(file io.Reader, path, field, dateField string, add func(value, date string)) error {
+	var doc any
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&doc); err != nil {
+		return err
+	}
+
+	var keys []string
+	if path != "" {
+		keys = strings.Split(path, ".")
+	}
+
+	walkJSON(doc, keys, func(entry any) {
+		if field == "" {
+			if value, ok := entry.(string); ok {
+				add(value, "")
+			}
+			return
+		}
+		obj, ok := entry.(map[string]any)
+		if !ok {
+			return
+		}
+		value, _ := obj[field].(string)
+		add(value, jsonString(obj[dateField]))
+	})
+	return nil
+}
+
+// walkJSON calls fn for every value reached by following keys from v.
+// Arrays are flattened at every level, including the last.
+func walkJSON(v any, keys []string, fn func(any)) {
+	if arr, ok := v.([]any); ok {
+		for _, elem := range arr {
+			walkJSON(elem, keys, fn)
+		}
+		return
+	}
+	if len(keys) == 0 {
+		fn(v)
+		return
+	}
+	if obj, ok := v.(map[string]any); ok {
+		if next, ok := obj[keys[0]]; ok {
+			walkJSON(next, keys[1:], fn)
+		}
+	}
+}
+
+// jsonString formats a JSON string or number as a string, for date fields
+// that may hold either an ISO date or Unix seconds
+func jsonString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return ""
+}
@@ -41,6 +41,12 @@ func (r *GeoLite2ASNReader) LookupTo(ip net.IP, record *GeoLite2ASNRecord) error
 	return r.Reader.Lookup(ip, record)
 }
 
+// LookupNetworkTo looks up an IP address into a pre-allocated record and
+// returns the network the record belongs to
+func (r *GeoLite2ASNReader) LookupNetworkTo(ip net.IP, record *GeoLite2ASNRecord) (*net.IPNet, bool, error) {
+	return r.Reader.LookupNetwork(ip, record)
+}
+
 // LookupNetwork looks up an IP and returns the network and record
 func (r *GeoLite2ASNReader) LookupNetwork(ip net.IP) (*net.IPNet, *GeoLite2ASNRecord, bool, error) {
 	var record GeoLite2ASNRecord
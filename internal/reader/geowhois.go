@@ -40,6 +40,12 @@ func (r *GeoWhoisCountryReader) LookupTo(ip net.IP, record *GeoWhoisCountryRecor
 	return r.Reader.Lookup(ip, record)
 }
 
+// LookupNetworkTo looks up an IP address into a pre-allocated record and
+// returns the network the record belongs to
+func (r *GeoWhoisCountryReader) LookupNetworkTo(ip net.IP, record *GeoWhoisCountryRecord) (*net.IPNet, bool, error) {
+	return r.Reader.LookupNetwork(ip, record)
+}
+
 // LookupNetwork looks up an IP and returns the network and record
 func (r *GeoWhoisCountryReader) LookupNetwork(ip net.IP) (*net.IPNet, *GeoWhoisCountryRecord, bool, error) {
 	var record GeoWhoisCountryRecord
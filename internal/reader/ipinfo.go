@@ -52,6 +52,12 @@ func (r *IPinfoLiteReader) LookupTo(ip net.IP, record *IPinfoLiteRecord) error {
 	return r.Reader.Lookup(ip, record)
 }
 
+// LookupNetworkTo looks up an IP address into a pre-allocated record and
+// returns the network the record belongs to
+func (r *IPinfoLiteReader) LookupNetworkTo(ip net.IP, record *IPinfoLiteRecord) (*net.IPNet, bool, error) {
+	return r.Reader.LookupNetwork(ip, record)
+}
+
 // LookupNetwork looks up an IP and returns the network and record
 func (r *IPinfoLiteReader) LookupNetwork(ip net.IP) (*net.IPNet, *IPinfoLiteRecord, bool, error) {
 	var record IPinfoLiteRecord
@@ -9,12 +9,9 @@ import (
 	"net"
 	"net/netip"
 	"os"
-	"sort"
 	"strings"
 
 	"merged-ip-data/internal/config"
-
-	"go4.org/netipx"
 )
 
 // OpenproxyDBRecord represents proxy/anonymity flags for an IP address
@@ -26,34 +23,46 @@ type OpenproxyDBRecord struct {
 	IsCDN       bool
 	IsSchool    bool // school-block
 	IsAnonymous bool // computed: IsProxy OR IsVPN OR IsTor
-}
 
-// cidrEntry holds a CIDR prefix and its associated proxy record
-type cidrEntry struct {
-	prefix netip.Prefix
-	record OpenproxyDBRecord
+	// Raw OpenProxyDB categories folded into IsProxy, kept separately so
+	// consumers can tell a Wikipedia-style rangeblock from an open proxy.
+	IsAnonblock  bool // anonblock
+	IsOpenProxy  bool // proxy
+	IsRangeblock bool // rangeblock
+
+	IsTorExit bool // Tor relay whose exit policy allows traffic to leave the network
+
+	// Evidence: which lists flagged the address and, where the input
+	// provides it, when it was first and last observed (YYYY-MM-DD).
+	Sources   ProxySource
+	FirstSeen string
+	LastSeen  string
+
+	// CDNProvider is the organization announcing the anycast prefix
+	// containing the address, when known
+	CDNProvider string
 }
 
 // OpenproxyDBReader reads and queries the OpenProxyDB CSV database.
 // Uses optimized data structures for fast lookups:
-// - Hash map for single IP addresses: O(1) lookup
-// - IPSet for fast CIDR containment check: O(log n)
-// - Sorted slice with binary search for CIDR record retrieval: O(log n)
+//   - Hash map for single IP addresses: O(1) lookup
+//   - Flattened range table for CIDR ranges: one O(log n) binary search that
+//     returns the most specific match, however deeply the ranges nest
 type OpenproxyDBReader struct {
 	singleIPs map[netip.Addr]OpenproxyDBRecord
 
-	// cidrSet provides fast O(log n) containment check
-	cidrSet *netipx.IPSet
+	// cidrRanges holds every CIDR range as loaded, sorted by address
+	cidrRanges []prefixValue[OpenproxyDBRecord]
 
-	// cidrRanges stores records sorted by prefix for binary search lookup
-	// after confirming containment via cidrSet
-	cidrRanges []cidrEntry
+	// cidrTable is the non-overlapping view of cidrRanges used for lookups
+	cidrTable *rangeTable[OpenproxyDBRecord]
 
-	// anycastSet holds the union of bgp.tools anycast prefixes (v4 + v6).
-	// Any IP contained in this set gets IsCDN=true OR'd onto its OpenProxyDB
-	// record during lookup so the CDN tag coexists with any existing tags
-	// (Hosting, Proxy, VPN, Tor, ...) rather than overriding them.
-	anycastSet *netipx.IPSet
+	// overlays holds the prefixes of every flag feed (bgp.tools anycast,
+	// configured feeds). Any IP contained in an overlay gets the feed's flags
+	// OR'd onto its OpenProxyDB record during lookup so e.g. the CDN tag
+	// coexists with any existing tags (Hosting, Proxy, VPN, Tor, ...) rather
+	// than overriding them.
+	overlays []*feedOverlay
 }
 
 // OpenOpenproxyDB opens and parses the OpenProxyDB CSV file
@@ -65,38 +74,14 @@ func OpenOpenproxyDB() (*OpenproxyDBReader, error) {
 	defer file.Close()
 
 	reader := &OpenproxyDBReader{
-		singleIPs:  make(map[netip.Addr]OpenproxyDBRecord),
-		cidrRanges: make([]cidrEntry, 0),
+		singleIPs: make(map[netip.Addr]OpenproxyDBRecord),
 	}
 
 	if err := reader.parse(file); err != nil {
 		return nil, fmt.Errorf("failed to parse OpenProxyDB: %w", err)
 	}
 
-	// Sort CIDR ranges by prefix for binary search lookup
-	// Sort by address first, then by prefix length (more specific first)
-	sort.Slice(reader.cidrRanges, func(i, j int) bool {
-		pi, pj := reader.cidrRanges[i].prefix, reader.cidrRanges[j].prefix
-		addrCmp := pi.Addr().Compare(pj.Addr())
-		if addrCmp != 0 {
-			return addrCmp < 0
-		}
-		// More specific (larger prefix length) comes first
-		return pi.Bits() > pj.Bits()
-	})
-
-	// Build IPSet for fast O(log n) containment checks
-	if len(reader.cidrRanges) > 0 {
-		var builder netipx.IPSetBuilder
-		for i := range reader.cidrRanges {
-			builder.AddPrefix(reader.cidrRanges[i].prefix)
-		}
-		ipSet, err := builder.IPSet()
-		if err != nil {
-			return nil, fmt.Errorf("failed to build IPSet: %w", err)
-		}
-		reader.cidrSet = ipSet
-	}
+	reader.cidrTable = buildRangeTable(reader.cidrRanges)
 
 	return reader, nil
 }
@@ -105,7 +90,7 @@ func OpenOpenproxyDB() (*OpenproxyDBReader, error) {
 func (r *OpenproxyDBReader) parse(file *os.File) error {
 	bufferedReader := bufio.NewReaderSize(file, 256*1024)
 	csvReader := csv.NewReader(bufferedReader)
-	csvReader.FieldsPerRecord = 10
+	csvReader.FieldsPerRecord = -1 // rows may be ragged; missing columns read as empty
 	csvReader.ReuseRecord = true
 
 	// Read and validate header
@@ -137,6 +122,10 @@ func (r *OpenproxyDBReader) parse(file *os.File) error {
 	torIdx := colIndex["tor"]
 	webhostIdx := colIndex["webhost"]
 
+	// Optional observation timestamps, used when the export includes them
+	firstSeenIdx := findColumn(colIndex, "first_seen", "first-seen", "firstseen")
+	lastSeenIdx := findColumn(colIndex, "last_seen", "last-seen", "lastseen")
+
 	lineNum := 1
 	for {
 		lineNum++
@@ -148,20 +137,20 @@ func (r *OpenproxyDBReader) parse(file *os.File) error {
 			return fmt.Errorf("failed to read CSV line %d: %w", lineNum, err)
 		}
 
-		ipStr := strings.TrimSpace(row[ipIdx])
+		ipStr := strings.TrimSpace(csvField(row, ipIdx))
 		if ipStr == "" {
 			continue
 		}
 
 		// Parse boolean flags
-		anonblock := parseBool(row[anonblockIdx])
-		proxy := parseBool(row[proxyIdx])
-		vpn := parseBool(row[vpnIdx])
-		cdn := parseBool(row[cdnIdx])
-		rangeblock := parseBool(row[rangeblockIdx])
-		school := parseBool(row[schoolIdx])
-		tor := parseBool(row[torIdx])
-		webhost := parseBool(row[webhostIdx])
+		anonblock := parseBool(csvField(row, anonblockIdx))
+		proxy := parseBool(csvField(row, proxyIdx))
+		vpn := parseBool(csvField(row, vpnIdx))
+		cdn := parseBool(csvField(row, cdnIdx))
+		rangeblock := parseBool(csvField(row, rangeblockIdx))
+		school := parseBool(csvField(row, schoolIdx))
+		tor := parseBool(csvField(row, torIdx))
+		webhost := parseBool(csvField(row, webhostIdx))
 
 		// Build the record with computed fields
 		isProxy := anonblock || proxy || rangeblock
@@ -173,7 +162,15 @@ func (r *OpenproxyDBReader) parse(file *os.File) error {
 			IsCDN:       cdn,
 			IsSchool:    school,
 			IsAnonymous: isProxy || vpn || tor,
+
+			IsAnonblock:  anonblock,
+			IsOpenProxy:  proxy,
+			IsRangeblock: rangeblock,
+
+			Sources: SourceOpenProxyDB,
 		}
+		record.FirstSeen = parseSeenDate(csvField(row, firstSeenIdx))
+		record.LastSeen = parseSeenDate(csvField(row, lastSeenIdx))
 
 		// Skip records with no flags set
 		if !record.HasData() {
@@ -186,9 +183,9 @@ func (r *OpenproxyDBReader) parse(file *os.File) error {
 			if err != nil {
 				continue
 			}
-			r.cidrRanges = append(r.cidrRanges, cidrEntry{
+			r.cidrRanges = append(r.cidrRanges, prefixValue[OpenproxyDBRecord]{
 				prefix: prefix,
-				record: record,
+				value:  record,
 			})
 		} else {
 			addr, err := netip.ParseAddr(ipStr)
@@ -202,6 +199,26 @@ func (r *OpenproxyDBReader) parse(file *os.File) error {
 	return nil
 }
 
+// findColumn returns the index of the first of names present in colIndex,
+// or -1 when none is
+func findColumn(colIndex map[string]int, names ...string) int {
+	for _, name := range names {
+		if idx, ok := colIndex[name]; ok {
+			return idx
+		}
+	}
+	return -1
+}
+
+// csvField returns column idx of row, or "" when idx is -1 or the row is
+// shorter than the header
+func csvField(row []string, idx int) string {
+	if idx < 0 || idx >= len(row) {
+		return ""
+	}
+	return row[idx]
+}
+
 // parseBool parses a boolean string (True/False) to bool
 func parseBool(s string) bool {
 	s = strings.TrimSpace(strings.ToLower(s))
@@ -225,124 +242,92 @@ func (r *OpenproxyDBReader) Lookup(ip net.IP) *OpenproxyDBRecord {
 // LookupTo looks up an IP address into a pre-allocated record to reduce allocations.
 // Returns true if a record was found.
 func (r *OpenproxyDBReader) LookupTo(ip net.IP, record *OpenproxyDBRecord) bool {
+	_, found := r.LookupNetworkTo(ip, record)
+	return found
+}
+
+// LookupNetworkTo is LookupTo, also returning the prefix length of the
+// single IP or CIDR range the record came from, or 0 when only a feed
+// overlay matched
+func (r *OpenproxyDBReader) LookupNetworkTo(ip net.IP, record *OpenproxyDBRecord) (bits int, found bool) {
 	addr, ok := netip.AddrFromSlice(ip)
 	if !ok {
-		return false
+		return 0, false
 	}
 	addr = addr.Unmap()
 
-	found := false
-
 	// Priority 1: Check single IP map first (single IPs take priority)
 	if rec, ok := r.singleIPs[addr]; ok {
 		*record = rec
+		bits = addr.BitLen()
 		found = true
-	} else if rec, ok := r.findInCIDR(addr); ok {
+	} else if match := r.cidrTable.lookup(addr); match != nil {
 		// Priority 2: Search CIDR ranges (find most specific match)
-		*record = rec
+		*record = match.value
+		bits = match.bits
 		found = true
 	}
 
-	// Overlay: bgp.tools anycast prefixes always contribute IsCDN=true on
-	// top of any existing OpenProxyDB tags. This lets CDN coexist with
-	// Hosting/Proxy/VPN/Tor rather than being shadowed when a more-specific
-	// OpenProxyDB CIDR match would otherwise omit the CDN flag.
-	if r.anycastSet != nil && r.anycastSet.Contains(addr) {
-		record.IsCDN = true
-		found = true
+	// Overlay: feed prefixes (e.g. bgp.tools anycast) always contribute
+	// their flags on top of any existing OpenProxyDB tags. This lets CDN
+	// coexist with Hosting/Proxy/VPN/Tor rather than being shadowed when a
+	// more-specific OpenProxyDB CIDR match would otherwise omit the CDN flag.
+	for _, overlay := range r.overlays {
+		if overlay.set != nil && overlay.set.Contains(addr) {
+			overlay.applyTo(addr, record)
+			found = true
+		}
 	}
 
-	return found
+	return bits, found
 }
 
-// findInCIDR searches for the most specific CIDR match for the given address.
-// Uses binary search for O(log n) lookup performance.
-func (r *OpenproxyDBReader) findInCIDR(addr netip.Addr) (OpenproxyDBRecord, bool) {
-	if len(r.cidrRanges) == 0 {
-		return OpenproxyDBRecord{}, false
+// LookupRangeTo is LookupNetworkTo for callers caching answers by network.
+// It also returns the largest prefix around ip over which the CIDR ranges and
+// feed overlays give the same answer. Single IPs are left out of that
+// prefix, except when ip is one (its host prefix is returned), so callers
+// must check IsSingleIP before reusing the answer for another address.
+func (r *OpenproxyDBReader) LookupRangeTo(ip net.IP, record *OpenproxyDBRecord) (network netip.Prefix, bits int, found bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, 0, false
 	}
+	addr = addr.Unmap()
 
-	// Fast path: use IPSet for quick containment check
-	if r.cidrSet != nil && !r.cidrSet.Contains(addr) {
-		return OpenproxyDBRecord{}, false
+	bits, found = r.LookupNetworkTo(ip, record)
+	if _, single := r.singleIPs[addr]; single {
+		return netip.PrefixFrom(addr, addr.BitLen()), bits, found
 	}
 
-	// Binary search to find a potential match region
-	// Find the rightmost prefix whose start address <= addr
-	idx := sort.Search(len(r.cidrRanges), func(i int) bool {
-		return r.cidrRanges[i].prefix.Addr().Compare(addr) > 0
-	})
-
-	// Search backwards from idx to find matching prefixes
-	// We need to find the most specific (highest bits) match
-	var bestMatch *cidrEntry
-	bestBits := -1
-
-	// Check entries before idx (they have start addr <= our addr)
-	for i := idx - 1; i >= 0; i-- {
-		entry := &r.cidrRanges[i]
-
-		// If this prefix's end is before our address, earlier entries won't match either
-		// (for same prefix length). But we need to check all potential matches.
-		if entry.prefix.Contains(addr) {
-			if entry.prefix.Bits() > bestBits {
-				bestMatch = entry
-				bestBits = entry.prefix.Bits()
-			}
-		}
-
-		// Optimization: if we've moved past addresses that could possibly contain addr,
-		// and we have a match, we can stop. This happens when the entry's masked network
-		// is completely before our address.
-		if bestMatch != nil {
-			// If we found a match and this entry's network end is before addr's network start
-			// at the same prefix length, we can stop searching
-			entryEnd := lastAddrInPrefix(entry.prefix)
-			if entryEnd.Compare(addr) < 0 {
-				break
-			}
+	lo, hi := r.cidrTable.span(addr)
+	network = prefixWithin(addr, lo, hi)
+	for _, overlay := range r.overlays {
+		lo, hi = overlay.spans.span(addr)
+		network = narrowPrefix(network, addr, lo, hi)
+		if overlay.details != nil {
+			lo, hi = overlay.details.span(addr)
+			network = narrowPrefix(network, addr, lo, hi)
 		}
 	}
+	return network, bits, found
+}
 
-	if bestMatch != nil {
-		return bestMatch.record, true
+// IsSingleIP reports whether ip has an entry of its own in the single IP map
+func (r *OpenproxyDBReader) IsSingleIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
 	}
-	return OpenproxyDBRecord{}, false
+	_, single := r.singleIPs[addr.Unmap()]
+	return single
 }
 
-// lastAddrInPrefix returns the last address in a prefix
-func lastAddrInPrefix(p netip.Prefix) netip.Addr {
-	addr := p.Addr()
-	if addr.Is4() {
-		bits := p.Bits()
-		if bits == 32 {
-			return addr
-		}
-		a4 := addr.As4()
-		hostBits := 32 - bits
-		mask := uint32((1 << hostBits) - 1)
-		val := uint32(a4[0])<<24 | uint32(a4[1])<<16 | uint32(a4[2])<<8 | uint32(a4[3])
-		val |= mask
-		return netip.AddrFrom4([4]byte{byte(val >> 24), byte(val >> 16), byte(val >> 8), byte(val)})
-	}
-	// IPv6
-	bits := p.Bits()
-	if bits == 128 {
-		return addr
-	}
-	a16 := addr.As16()
-	hostBits := 128 - bits
-	// Set all host bits to 1
-	for i := 15; i >= 0 && hostBits > 0; i-- {
-		if hostBits >= 8 {
-			a16[i] = 0xFF
-			hostBits -= 8
-		} else {
-			a16[i] |= byte((1 << hostBits) - 1)
-			hostBits = 0
-		}
+// findInCIDR returns the record of the most specific CIDR range containing addr
+func (r *OpenproxyDBReader) findInCIDR(addr netip.Addr) (OpenproxyDBRecord, bool) {
+	if match := r.cidrTable.lookup(addr); match != nil {
+		return match.value, true
 	}
-	return netip.AddrFrom16(a16)
+	return OpenproxyDBRecord{}, false
 }
 
 // HasData checks if the record has any proxy/anonymity flags set
@@ -359,72 +344,57 @@ func (r *OpenproxyDBRecord) Reset() {
 	r.IsCDN = false
 	r.IsSchool = false
 	r.IsAnonymous = false
+	r.IsAnonblock = false
+	r.IsOpenProxy = false
+	r.IsRangeblock = false
+	r.IsTorExit = false
+	r.Sources = 0
+	r.FirstSeen = ""
+	r.LastSeen = ""
+	r.CDNProvider = ""
 }
 
-// LoadBadIPList reads a plain-text file of IPs (one per line) and merges them
-// into the single IP lookup map with IsProxy=true and IsAnonymous=true.
-// Existing OpenProxyDB records are preserved; only the proxy/anonymous flags
-// are ensured set for IPs that already exist.
-func (r *OpenproxyDBReader) LoadBadIPList(path string) (int, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open bad IP list: %w", err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	count := 0
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		addr, err := netip.ParseAddr(line)
-		if err != nil {
-			continue
-		}
-
-		if existing, found := r.singleIPs[addr]; found {
-			// Merge: ensure proxy and anonymous flags are set
-			existing.IsProxy = true
-			existing.IsAnonymous = true
-			r.singleIPs[addr] = existing
-		} else {
-			rec := OpenproxyDBRecord{
-				IsProxy:     true,
-				IsAnonymous: true,
-			}
-			// Inherit any CIDR-level flags covering this IP (e.g. Hosting)
-			// so they coexist with the proxy flag on the /32 record.
-			if cidr, ok := r.findInCIDR(addr); ok {
-				rec.IsVPN = rec.IsVPN || cidr.IsVPN
-				rec.IsTor = rec.IsTor || cidr.IsTor
-				rec.IsHosting = rec.IsHosting || cidr.IsHosting
-				rec.IsCDN = rec.IsCDN || cidr.IsCDN
-				rec.IsSchool = rec.IsSchool || cidr.IsSchool
-			}
-			r.singleIPs[addr] = rec
-		}
-		count++
-	}
-
-	if err := scanner.Err(); err != nil {
-		return count, fmt.Errorf("error reading bad IP list: %w", err)
+// inheritFlags ORs every flag set on other onto r. Used when a single-IP
+// entry is created inside an existing CIDR so the CIDR-level tags coexist
+// with the new ones instead of being shadowed by the more specific match.
+func (r *OpenproxyDBRecord) inheritFlags(other *OpenproxyDBRecord) {
+	r.IsProxy = r.IsProxy || other.IsProxy
+	r.IsVPN = r.IsVPN || other.IsVPN
+	r.IsTor = r.IsTor || other.IsTor
+	r.IsHosting = r.IsHosting || other.IsHosting
+	r.IsCDN = r.IsCDN || other.IsCDN
+	r.IsSchool = r.IsSchool || other.IsSchool
+	r.IsAnonymous = r.IsAnonymous || other.IsAnonymous
+	r.IsAnonblock = r.IsAnonblock || other.IsAnonblock
+	r.IsOpenProxy = r.IsOpenProxy || other.IsOpenProxy
+	r.IsRangeblock = r.IsRangeblock || other.IsRangeblock
+	r.IsTorExit = r.IsTorExit || other.IsTorExit
+	r.Sources |= other.Sources
+	r.observe(other.FirstSeen, other.LastSeen)
+	if r.CDNProvider == "" {
+		r.CDNProvider = other.CDNProvider
 	}
+}
 
-	return count, nil
+// observe widens the first/last-seen window to include the given dates
+func (r *OpenproxyDBRecord) observe(firstSeen, lastSeen string) {
+	r.FirstSeen = EarlierDate(r.FirstSeen, firstSeen)
+	r.LastSeen = LaterDate(r.LastSeen, lastSeen)
 }
 
 // LoadTorRelays reads the Onionoo JSON file of running Tor relays and merges
 // their IP addresses into the single IP lookup map with IsTor=true and
-// IsAnonymous=true. The JSON is expected to have been fetched with the
-// fields=or_addresses,exit_addresses parameter so that only address data is
-// present, keeping the download size manageable.
+// IsAnonymous=true. Relays that act as exits additionally get IsTorExit=true.
+// The JSON is expected to have been fetched with the
+// fields=or_addresses,exit_addresses,flags,exit_policy_summary,first_seen,last_seen
+// parameter so that only address, exit and observation data is present,
+// keeping the download size manageable. Each address carries the earliest
+// first_seen and latest last_seen of the relays using it.
 //
 // or_addresses entries are in "ip:port" format (IPv6 in brackets, e.g.
 // "[2001:db8::1]:9001"), and exit_addresses entries are plain IP strings.
+// A relay is treated as an exit when it carries the "Exit" flag or its exit
+// policy summary permits any port (see torRelay.isExit).
 // The method uses a streaming JSON decoder to handle large responses
 // efficiently without loading the entire array into memory at once.
 func (r *OpenproxyDBReader) LoadTorRelays(path string) (int, error) {
@@ -442,7 +412,7 @@ func (r *OpenproxyDBReader) LoadTorRelays(path string) (int, error) {
 		return 0, fmt.Errorf("failed to read JSON start: %w", err)
 	}
 
-	uniqueIPs := make(map[netip.Addr]struct{})
+	uniqueIPs := make(map[netip.Addr]torAddress)
 
 	// Stream through top-level keys until we find "relays"
 	for decoder.More() {
@@ -463,32 +433,35 @@ func (r *OpenproxyDBReader) LoadTorRelays(path string) (int, error) {
 			}
 
 			// Stream each relay object
-			var relay struct {
-				ORAddresses   []string `json:"or_addresses"`
-				ExitAddresses []string `json:"exit_addresses"`
-			}
+			var relay torRelay
 
 			for decoder.More() {
-				relay.ORAddresses = relay.ORAddresses[:0]
-				relay.ExitAddresses = relay.ExitAddresses[:0]
+				relay.reset()
 
 				if err := decoder.Decode(&relay); err != nil {
 					continue
 				}
 
+				exit := relay.isExit()
+				firstSeen := parseSeenDate(relay.FirstSeen)
+				lastSeen := parseSeenDate(relay.LastSeen)
+
 				// Parse or_addresses: format is "ip:port" or "[ipv6]:port"
 				for _, orAddr := range relay.ORAddresses {
 					ip := parseTorORAddress(orAddr)
 					if ip.IsValid() {
-						uniqueIPs[ip] = struct{}{}
+						uniqueIPs[ip] = uniqueIPs[ip].merge(exit, firstSeen, lastSeen)
 					}
 				}
 
-				// Parse exit_addresses: plain IP strings
+				// Parse exit_addresses: plain IP strings. Traffic observed
+				// leaving the network from these addresses, so they are exits
+				// whenever the relay itself is.
 				for _, exitAddr := range relay.ExitAddresses {
 					addr, err := netip.ParseAddr(strings.TrimSpace(exitAddr))
 					if err == nil {
-						uniqueIPs[addr.Unmap()] = struct{}{}
+						addr = addr.Unmap()
+						uniqueIPs[addr] = uniqueIPs[addr].merge(exit, firstSeen, lastSeen)
 					}
 				}
 			}
@@ -509,26 +482,29 @@ func (r *OpenproxyDBReader) LoadTorRelays(path string) (int, error) {
 
 	// Merge unique IPs into the single IP map
 	count := 0
-	for addr := range uniqueIPs {
+	for addr, info := range uniqueIPs {
 		if existing, found := r.singleIPs[addr]; found {
 			// Merge: ensure Tor and anonymous flags are set
 			existing.IsTor = true
+			existing.IsTorExit = existing.IsTorExit || info.exit
 			existing.IsAnonymous = true
+			existing.Sources |= SourceTor
+			existing.observe(info.firstSeen, info.lastSeen)
 			r.singleIPs[addr] = existing
 		} else {
 			rec := OpenproxyDBRecord{
 				IsTor:       true,
+				IsTorExit:   info.exit,
 				IsAnonymous: true,
+				Sources:     SourceTor,
+				FirstSeen:   info.firstSeen,
+				LastSeen:    info.lastSeen,
 			}
 			// Inherit any CIDR-level flags covering this IP (e.g. Hosting,
 			// Proxy, VPN) so the Tor tag coexists with them on the /32 record
 			// rather than overriding them.
 			if cidr, ok := r.findInCIDR(addr); ok {
-				rec.IsProxy = rec.IsProxy || cidr.IsProxy
-				rec.IsVPN = rec.IsVPN || cidr.IsVPN
-				rec.IsHosting = rec.IsHosting || cidr.IsHosting
-				rec.IsCDN = rec.IsCDN || cidr.IsCDN
-				rec.IsSchool = rec.IsSchool || cidr.IsSchool
+				rec.inheritFlags(&cidr)
 			}
 			r.singleIPs[addr] = rec
 		}
@@ -538,6 +514,68 @@ func (r *OpenproxyDBReader) LoadTorRelays(path string) (int, error) {
 	return count, nil
 }
 
+// torRelay holds the subset of an Onionoo relay details document used to
+// classify relay addresses.
+type torRelay struct {
+	ORAddresses       []string `json:"or_addresses"`
+	ExitAddresses     []string `json:"exit_addresses"`
+	Flags             []string `json:"flags"`
+	ExitPolicySummary struct {
+		Accept []string `json:"accept"`
+		Reject []string `json:"reject"`
+	} `json:"exit_policy_summary"`
+	FirstSeen string `json:"first_seen"`
+	LastSeen  string `json:"last_seen"`
+}
+
+// torAddress aggregates what is known about one address across all relays
+// that use it
+type torAddress struct {
+	exit      bool
+	firstSeen string
+	lastSeen  string
+}
+
+// merge folds one relay's observation into the address summary
+func (a torAddress) merge(exit bool, firstSeen, lastSeen string) torAddress {
+	a.exit = a.exit || exit
+	a.firstSeen = EarlierDate(a.firstSeen, firstSeen)
+	a.lastSeen = LaterDate(a.lastSeen, lastSeen)
+	return a
+}
+
+// reset clears the relay for reuse while keeping slice capacity
+func (t *torRelay) reset() {
+	t.ORAddresses = t.ORAddresses[:0]
+	t.ExitAddresses = t.ExitAddresses[:0]
+	t.Flags = t.Flags[:0]
+	t.ExitPolicySummary.Accept = t.ExitPolicySummary.Accept[:0]
+	t.ExitPolicySummary.Reject = t.ExitPolicySummary.Reject[:0]
+	t.FirstSeen = ""
+	t.LastSeen = ""
+}
+
+// isExit reports whether the relay lets traffic leave the Tor network. The
+// directory authorities' "Exit" flag is only assigned to relays allowing a
+// minimum set of common ports, so the exit policy summary is also consulted:
+// any accepted port, or a reject list that does not cover every port, means
+// the relay can act as an exit for at least some destinations.
+func (t *torRelay) isExit() bool {
+	for _, flag := range t.Flags {
+		if flag == "Exit" {
+			return true
+		}
+	}
+
+	if len(t.ExitPolicySummary.Accept) > 0 {
+		return true
+	}
+	if len(t.ExitPolicySummary.Reject) > 0 {
+		return !(len(t.ExitPolicySummary.Reject) == 1 && t.ExitPolicySummary.Reject[0] == "1-65535")
+	}
+	return false
+}
+
 // parseTorORAddress extracts the IP address from a Tor OR address string.
 // Formats: "1.2.3.4:9001" for IPv4, "[2001:db8::1]:9001" for IPv6.
 func parseTorORAddress(orAddr string) netip.Addr {
@@ -586,98 +624,14 @@ func (r *OpenproxyDBReader) SingleIPs() map[netip.Addr]OpenproxyDBRecord {
 	return r.singleIPs
 }
 
-// LoadAnycastPrefixes reads one or more plain-text CIDR prefix list files (as
-// published by bgp.tools anycast-prefixes) and builds the anycast lookup set.
-// Blank lines and '#'-prefixed comments are skipped. Bare IP addresses are
-// treated as /32 or /128 prefixes. Call this after any LoadBadIPList or
-// LoadTorRelays calls so the sweep at the end picks up every single-IP entry.
-//
-// After the set is built, every single-IP record that falls inside an anycast
-// prefix has IsCDN OR'd true in place so the merger's direct /32 and /128
-// insertion path carries the CDN flag alongside the existing tags. CIDR-level
-// lookups pick up the CDN overlay automatically via LookupTo.
-func (r *OpenproxyDBReader) LoadAnycastPrefixes(paths ...string) (int, error) {
-	var builder netipx.IPSetBuilder
-	loaded := 0
-
-	for _, path := range paths {
-		n, err := r.parseAnycastFile(path, &builder)
-		if err != nil {
-			return loaded, err
-		}
-		loaded += n
-	}
-
-	if loaded == 0 {
-		return 0, nil
-	}
-
-	ipSet, err := builder.IPSet()
-	if err != nil {
-		return loaded, fmt.Errorf("failed to build anycast IPSet: %w", err)
-	}
-	r.anycastSet = ipSet
-
-	for addr, rec := range r.singleIPs {
-		if !rec.IsCDN && ipSet.Contains(addr) {
-			rec.IsCDN = true
-			r.singleIPs[addr] = rec
-		}
-	}
-
-	return loaded, nil
-}
-
-// parseAnycastFile reads a single prefix-list file and adds each entry to the
-// supplied builder. Returns the number of prefixes successfully added.
-func (r *OpenproxyDBReader) parseAnycastFile(path string, builder *netipx.IPSetBuilder) (int, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open anycast prefix file %s: %w", path, err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-
-	count := 0
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		prefix, err := netip.ParsePrefix(line)
-		if err != nil {
-			addr, addrErr := netip.ParseAddr(line)
-			if addrErr != nil {
-				continue
-			}
-			bits := 32
-			if addr.Is6() {
-				bits = 128
-			}
-			prefix = netip.PrefixFrom(addr, bits)
-		}
-
-		builder.AddPrefix(prefix)
-		count++
-	}
-
-	if err := scanner.Err(); err != nil {
-		return count, fmt.Errorf("error reading anycast prefix file %s: %w", path, err)
-	}
-
-	return count, nil
-}
-
-// AnycastPrefixCount returns the number of anycast prefixes currently loaded.
-// Returns 0 if no anycast set has been built.
-func (r *OpenproxyDBReader) AnycastPrefixCount() int {
-	if r.anycastSet == nil {
-		return 0
+// ForEachCIDR calls fn for every OpenProxyDB CIDR range in address order,
+// least specific first.
+// The feed overlays are not applied to the records; use ForEachFeedPrefix
+// for that.
+func (r *OpenproxyDBReader) ForEachCIDR(fn func(prefix netip.Prefix, record OpenproxyDBRecord)) {
+	for i := range r.cidrRanges {
+		fn(r.cidrRanges[i].prefix, r.cidrRanges[i].value)
 	}
-	return len(r.anycastSet.Prefixes())
 }
 
 // Stats returns the count of single IPs and CIDR ranges loaded
@@ -0,0 +1,255 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+
+	"merged-ip-data/internal/config"
+)
+
+// ProxyOverride forces proxy flags on, and off, every address in a prefix.
+// Clear is applied before Set; a flag is never in both.
+type ProxyOverride struct {
+	Prefix netip.Prefix
+	Set    ProxyFlag
+	Clear  ProxyFlag
+}
+
+// GeoOverride corrects the country, and optionally the city, of a prefix
+type GeoOverride struct {
+	Prefix      netip.Prefix
+	CountryCode string
+	City        string // English city name; empty to leave the city unset
+}
+
+// ASOrgOverride renames an AS organization, and optionally sets its domain
+type ASOrgOverride struct {
+	Organization string
+	Domain       string
+}
+
+// Overrides holds the local corrections loaded from the override files in
+// config. They are applied after all upstream sources and win over them,
+// so known-bad data can be fixed without code changes or waiting on upstream.
+type Overrides struct {
+	BadASNAdd     map[uint32]BadASNEntry
+	BadASNRemove  map[uint32]struct{}
+	ProxyPrefixes []ProxyOverride
+	GeoPrefixes   []GeoOverride
+	ASOrgs        map[uint32]ASOrgOverride
+	Allowlist     *Allowlist
+}
+
+// LoadOverrides reads every override file in config. Missing files are not
+// an error; the corresponding overrides are simply empty. Each file is CSV
+// without a header, with '#' comments:
+//
+//	bad-asns.csv:       asn,add|remove[,type][,entity]
+//	proxy-prefixes.csv: prefix,flags            (flags e.g. "hosting;-proxy")
+//	geo-prefixes.csv:   prefix,country[,city]
+//	as-orgs.csv:        asn,organization[,domain]
+//	allowlist.csv:      prefix|asn[,flags]      (flags default to "all")
+func LoadOverrides() (*Overrides, error) {
+	o := &Overrides{
+		BadASNAdd:    make(map[uint32]BadASNEntry),
+		BadASNRemove: make(map[uint32]struct{}),
+		ASOrgs:       make(map[uint32]ASOrgOverride),
+		Allowlist:    newAllowlist(),
+	}
+
+	loaders := []struct {
+		path  string
+		parse func(row []string) error
+	}{
+		{config.OverrideBadASNFile, o.parseBadASN},
+		{config.OverrideProxyPrefixesFile, o.parseProxyPrefix},
+		{config.OverrideGeoPrefixesFile, o.parseGeoPrefix},
+		{config.OverrideASOrgFile, o.parseASOrg},
+		{config.OverrideAllowlistFile, o.Allowlist.parseRow},
+	}
+	for _, l := range loaders {
+		if err := readLocalCSV(l.path, l.parse); err != nil {
+			return nil, err
+		}
+	}
+	o.Allowlist.build()
+
+	return o, nil
+}
+
+// readLocalCSV calls parse for every row of an optional local CSV file.
+// Returns nil if the file does not exist. Errors are annotated with the file
+// and line so mistakes in hand-edited files are easy to find.
+func readLocalCSV(path string, parse func(row []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	csvReader := csv.NewReader(bufio.NewReader(file))
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comment = '#'
+	csvReader.TrimLeadingSpace = true
+
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for i := range row {
+			row[i] = strings.TrimSpace(row[i])
+		}
+		if len(row) == 0 || (len(row) == 1 && row[0] == "") {
+			continue
+		}
+		if err := parse(row); err != nil {
+			line, _ := csvReader.FieldPos(0)
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+}
+
+func (o *Overrides) parseBadASN(row []string) error {
+	if len(row) < 2 {
+		return errors.New("expected asn,add|remove[,type][,entity]")
+	}
+	asn, ok := parseASNField(row[0])
+	if !ok {
+		return fmt.Errorf("invalid ASN %q", row[0])
+	}
+
+	switch strings.ToLower(row[1]) {
+	case "add":
+		var entry BadASNEntry
+		if len(row) > 2 {
+			entry.Category = ParseBadASNCategory(row[2])
+			if entry.Category == 0 && row[2] != "" {
+				return fmt.Errorf("unknown bad ASN type %q", row[2])
+			}
+		}
+		if len(row) > 3 {
+			entry.Entity = row[3]
+		}
+		o.BadASNAdd[asn] = entry
+		delete(o.BadASNRemove, asn)
+	case "remove":
+		o.BadASNRemove[asn] = struct{}{}
+		delete(o.BadASNAdd, asn)
+	default:
+		return fmt.Errorf("unknown action %q (want add or remove)", row[1])
+	}
+	return nil
+}
+
+func (o *Overrides) parseProxyPrefix(row []string) error {
+	if len(row) < 2 {
+		return errors.New("expected prefix,flags")
+	}
+	prefix, err := parsePrefixOrAddr(row[0])
+	if err != nil {
+		return err
+	}
+	set, clear, err := ParseProxyFlagChanges(row[1])
+	if err != nil {
+		return err
+	}
+	o.ProxyPrefixes = append(o.ProxyPrefixes, ProxyOverride{Prefix: prefix, Set: set, Clear: clear})
+	return nil
+}
+
+func (o *Overrides) parseGeoPrefix(row []string) error {
+	if len(row) < 2 {
+		return errors.New("expected prefix,country[,city]")
+	}
+	prefix, err := parsePrefixOrAddr(row[0])
+	if err != nil {
+		return err
+	}
+	country := strings.ToUpper(row[1])
+	if len(country) != 2 {
+		return fmt.Errorf("invalid country code %q", row[1])
+	}
+	override := GeoOverride{Prefix: prefix, CountryCode: country}
+	if len(row) > 2 {
+		override.City = row[2]
+	}
+	o.GeoPrefixes = append(o.GeoPrefixes, override)
+	return nil
+}
+
+func (o *Overrides) parseASOrg(row []string) error {
+	if len(row) < 2 {
+		return errors.New("expected asn,organization[,domain]")
+	}
+	asn, ok := parseASNField(row[0])
+	if !ok {
+		return fmt.Errorf("invalid ASN %q", row[0])
+	}
+	override := ASOrgOverride{Organization: row[1]}
+	if len(row) > 2 {
+		override.Domain = row[2]
+	}
+	o.ASOrgs[asn] = override
+	return nil
+}
+
+// parsePrefixOrAddr parses a CIDR prefix, treating a bare address as a /32
+// or /128
+func parsePrefixOrAddr(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ApplyToBadASN adds and removes the overridden ASNs on r
+func (o *Overrides) ApplyToBadASN(r *BadASNReader) {
+	if o == nil || r == nil {
+		return
+	}
+	for asn, entry := range o.BadASNAdd {
+		// Overrides replace rather than extend the upstream entry
+		r.Remove(asn)
+		r.Add(asn, entry)
+	}
+	for asn := range o.BadASNRemove {
+		r.Remove(asn)
+	}
+}
+
+// ASOrg returns the organization override for asn, if any. Safe to call on
+// a nil receiver.
+func (o *Overrides) ASOrg(asn uint32) (ASOrgOverride, bool) {
+	if o == nil || asn == 0 {
+		return ASOrgOverride{}, false
+	}
+	override, ok := o.ASOrgs[asn]
+	return override, ok
+}
+
+// Count returns the total number of override entries loaded
+func (o *Overrides) Count() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.BadASNAdd) + len(o.BadASNRemove) + len(o.ProxyPrefixes) +
+		len(o.GeoPrefixes) + len(o.ASOrgs) + o.Allowlist.Count()
+}
@@ -0,0 +1,116 @@
+package reader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProxyFlag is a bit set naming proxy record flags. It is used by local
+// configuration (overrides, allowlists, feeds) to say which flags to set or
+// clear.
+type ProxyFlag uint16
+
+// Proxy record flags
+const (
+	FlagProxy ProxyFlag = 1 << iota
+	FlagVPN
+	FlagTor
+	FlagTorExit
+	FlagHosting
+	FlagCDN
+	FlagSchool
+	FlagAnonymous
+	FlagAnonblock
+	FlagOpenProxy
+	FlagRangeblock
+
+	// FlagAll names every flag
+	FlagAll ProxyFlag = 1<<iota - 1
+)
+
+// proxyFlagNames maps configuration names to flags. The names match the
+// output keys without their "is_" prefix.
+var proxyFlagNames = map[string]ProxyFlag{
+	"proxy":      FlagProxy,
+	"vpn":        FlagVPN,
+	"tor":        FlagTor,
+	"tor_exit":   FlagTorExit,
+	"hosting":    FlagHosting,
+	"cdn":        FlagCDN,
+	"school":     FlagSchool,
+	"anonymous":  FlagAnonymous,
+	"anonblock":  FlagAnonblock,
+	"open_proxy": FlagOpenProxy,
+	"rangeblock": FlagRangeblock,
+	"all":        FlagAll,
+}
+
+// ParseProxyFlags parses a list of flag names separated by ';', '|' or
+// whitespace, e.g. "proxy;hosting". "all" names every flag.
+func ParseProxyFlags(s string) (ProxyFlag, error) {
+	var flags ProxyFlag
+	for _, name := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ';' || r == '|' || r == ' ' || r == '\t'
+	}) {
+		flag, ok := proxyFlagNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown proxy flag %q", name)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// ParseProxyFlagChanges parses a list of flags to set and to clear, e.g.
+// "hosting;-proxy": names as in ParseProxyFlags, with a leading '-' marking a
+// flag to clear. An empty list, or a flag both set and cleared, is an error.
+func ParseProxyFlagChanges(s string) (set, clear ProxyFlag, err error) {
+	for _, name := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ';' || r == '|' || r == ' ' || r == '\t'
+	}) {
+		target := &set
+		if rest, ok := strings.CutPrefix(name, "-"); ok {
+			name, target = rest, &clear
+		}
+		flag, ok := proxyFlagNames[name]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown proxy flag %q", name)
+		}
+		*target |= flag
+	}
+	if set == 0 && clear == 0 {
+		return 0, 0, fmt.Errorf("no proxy flags in %q", s)
+	}
+	if set&clear != 0 {
+		return 0, 0, fmt.Errorf("proxy flags %q both set and cleared", s)
+	}
+	return set, clear, nil
+}
+
+// SetFlags sets every flag in flags on the record. Setting an anonymizing
+// flag (proxy, VPN, Tor or one of the raw proxy categories) also sets
+// IsAnonymous, and a raw proxy category also sets IsProxy, keeping the
+// derived flags consistent with parse.
+func (r *OpenproxyDBRecord) SetFlags(flags ProxyFlag) {
+	if flags&(FlagAnonblock|FlagOpenProxy|FlagRangeblock) != 0 {
+		flags |= FlagProxy
+	}
+	if flags&FlagTorExit != 0 {
+		flags |= FlagTor
+	}
+	if flags&(FlagProxy|FlagVPN|FlagTor) != 0 {
+		flags |= FlagAnonymous
+	}
+
+	r.IsProxy = r.IsProxy || flags&FlagProxy != 0
+	r.IsVPN = r.IsVPN || flags&FlagVPN != 0
+	r.IsTor = r.IsTor || flags&FlagTor != 0
+	r.IsTorExit = r.IsTorExit || flags&FlagTorExit != 0
+	r.IsHosting = r.IsHosting || flags&FlagHosting != 0
+	r.IsCDN = r.IsCDN || flags&FlagCDN != 0
+	r.IsSchool = r.IsSchool || flags&FlagSchool != 0
+	r.IsAnonymous = r.IsAnonymous || flags&FlagAnonymous != 0
+	r.IsAnonblock = r.IsAnonblock || flags&FlagAnonblock != 0
+	r.IsOpenProxy = r.IsOpenProxy || flags&FlagOpenProxy != 0
+	r.IsRangeblock = r.IsRangeblock || flags&FlagRangeblock != 0
+}
@@ -0,0 +1,161 @@
+package reader
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxySource is a bit set identifying which lists flagged an address.
+// Several sources can contribute to the same record. The built-in sources
+// have fixed bits; flag feeds get the next free bit in the ProxySources
+// returned by LoadFeeds.
+type ProxySource uint32
+
+// Known proxy evidence sources
+const (
+	SourceOpenProxyDB ProxySource = 1 << iota
+	SourceBadIPList
+	SourceTor
+	SourceAnycast
+	SourceBadASN
+	SourceOverride
+	SourceCloud
+)
+
+// proxySourceEntry names a source and, for flag feeds, its risk weight
+type proxySourceEntry struct {
+	source ProxySource
+	name   string
+	weight int
+}
+
+// builtinProxySources lists the output name of every built-in source in
+// emission order
+var builtinProxySources = []proxySourceEntry{
+	{source: SourceOpenProxyDB, name: "openproxydb"},
+	{source: SourceBadIPList, name: "badiplist"},
+	{source: SourceTor, name: "tor"},
+	{source: SourceAnycast, name: "anycast"},
+	{source: SourceBadASN, name: "bad_asn"},
+	{source: SourceOverride, name: "override"},
+	{source: SourceCloud, name: "cloud"},
+}
+
+// ProxySources names the bits of ProxySource: the built-in sources followed
+// by the flag feeds registered while loading them. A nil *ProxySources
+// knows the built-in sources only.
+type ProxySources struct {
+	entries []proxySourceEntry
+}
+
+// NewProxySources returns a registry holding the built-in sources
+func NewProxySources() *ProxySources {
+	return &ProxySources{entries: slices.Clone(builtinProxySources)}
+}
+
+// list returns the registered sources in emission order
+func (s *ProxySources) list() []proxySourceEntry {
+	if s == nil {
+		return builtinProxySources
+	}
+	return s.entries
+}
+
+// register returns the source with the given name, allocating a new bit for
+// it if the name is not known yet. weight is the risk weight of a newly
+// allocated source; built-in sources are weighted in config.
+func (s *ProxySources) register(name string, weight int) (ProxySource, error) {
+	if source := s.FromName(name); source != 0 {
+		return source, nil
+	}
+
+	last := s.entries[len(s.entries)-1].source
+	if last == 1<<31 {
+		return 0, fmt.Errorf("too many proxy sources, cannot register %q", name)
+	}
+	source := last << 1
+	s.entries = append(s.entries, proxySourceEntry{source: source, name: name, weight: weight})
+	return source, nil
+}
+
+// FeedWeight returns the sum of the risk weights of the feed sources in set
+func (s *ProxySources) FeedWeight(set ProxySource) int {
+	weight := 0
+	for _, entry := range s.list() {
+		if set&entry.source != 0 {
+			weight += entry.weight
+		}
+	}
+	return weight
+}
+
+// Names returns the names of all sources in set, in a stable order
+func (s *ProxySources) Names(set ProxySource) []string {
+	if set == 0 {
+		return nil
+	}
+	names := make([]string, 0, 2)
+	for _, entry := range s.list() {
+		if set&entry.source != 0 {
+			names = append(names, entry.name)
+		}
+	}
+	return names
+}
+
+// FromName returns the source with the given output name, or 0 if the name
+// is unknown
+func (s *ProxySources) FromName(name string) ProxySource {
+	for _, entry := range s.list() {
+		if entry.name == name {
+			return entry.source
+		}
+	}
+	return 0
+}
+
+// seenDateLayout is the normalized format for first/last-seen dates. ISO
+// dates compare correctly as plain strings, so no parsing is needed when
+// merging observations.
+const seenDateLayout = "2006-01-02"
+
+// parseSeenDate normalizes a timestamp from an input list to a YYYY-MM-DD
+// date. Accepts ISO dates, "YYYY-MM-DD hh:mm:ss" (Onionoo), RFC 3339 and Unix
+// seconds. Returns "" for empty or unrecognized values.
+func parseSeenDate(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+
+	for _, layout := range []string{seenDateLayout, "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format(seenDateLayout)
+		}
+	}
+
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil && secs > 0 {
+		return time.Unix(secs, 0).UTC().Format(seenDateLayout)
+	}
+
+	return ""
+}
+
+// EarlierDate returns the earlier of two YYYY-MM-DD dates, ignoring empty values
+func EarlierDate(a, b string) string {
+	if a == "" || (b != "" && b < a) {
+		return b
+	}
+	return a
+}
+
+// LaterDate returns the later of two YYYY-MM-DD dates, ignoring empty values
+func LaterDate(a, b string) string {
+	if b > a {
+		return b
+	}
+	return a
+}
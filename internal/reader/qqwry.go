@@ -1,7 +1,14 @@
 package reader
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"net/netip"
+	"os"
+	"slices"
 
 	"merged-ip-data/internal/config"
 
@@ -19,21 +26,143 @@ type QQWryRecord struct {
 	ContinentCode string // Continent code
 }
 
-// QQWryReader reads the QQWry IPDB database
+// QQWryReader reads the QQWry IPDB database. The main database is usually
+// IPv4-only; an optional IPv6 (or combined) IPDB file extends coverage to
+// IPv6 networks. Each address is looked up in whichever database supports
+// its family, the main one first.
 type QQWryReader struct {
-	db *ipdb.City
+	v4 *qqwryDB
+	v6 *qqwryDB
 }
 
-// OpenQQWry opens the QQWry IPDB database
+// qqwryDB is one opened IPDB file and the language its names are read in.
+// ipdb-go does not report the range a record covers, so the node tree is
+// kept to walk it directly (the slice shares the ipdb-go copy of the file).
+type qqwryDB struct {
+	db       *ipdb.City
+	language string
+
+	tree      []byte
+	nodeCount int
+	v4offset  int
+}
+
+// errQQWryUnsupported is returned for addresses of a family that no loaded
+// database covers
+var errQQWryUnsupported = errors.New("no QQWry database for address family")
+
+// OpenQQWry opens the QQWry IPDB database, and the optional IPv6 database
+// when it exists
 func OpenQQWry() (*QQWryReader, error) {
-	db, err := ipdb.NewCity(config.QQWryFile)
+	primary, err := openQQWryDB(config.QQWryFile)
 	if err != nil {
 		return nil, err
 	}
-	return &QQWryReader{db: db}, nil
+
+	r := &QQWryReader{}
+	if primary.db.IsIPv4() {
+		r.v4 = primary
+	}
+	if primary.db.IsIPv6() {
+		r.v6 = primary
+	}
+
+	if r.v6 == nil {
+		extra, err := openQQWryDB(config.QQWryIPv6File)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to open QQWry IPv6 database: %w", err)
+		}
+		if extra != nil {
+			if !extra.db.IsIPv6() {
+				return nil, fmt.Errorf("%s does not contain IPv6 data", config.QQWryIPv6File)
+			}
+			r.v6 = extra
+		}
+	}
+
+	return r, nil
+}
+
+// openQQWryDB opens an IPDB file, reading names in Chinese ("CN") when the
+// file provides them and in its first language otherwise
+func openQQWryDB(path string) (*qqwryDB, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := ipdb.NewCityFromBytes(body)
+	if err != nil {
+		return nil, err
+	}
+
+	language := "CN"
+	if languages := db.Languages(); len(languages) > 0 && !slices.Contains(languages, language) {
+		language = languages[0]
+	}
+
+	// The file is a 4-byte metadata length, the JSON metadata, then the
+	// node tree; NewCityFromBytes has validated the sizes
+	metaLength := int(binary.BigEndian.Uint32(body[0:4]))
+	var meta struct {
+		NodeCount int `json:"node_count"`
+	}
+	if err := json.Unmarshal(body[4:4+metaLength], &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse IPDB metadata: %w", err)
+	}
+
+	d := &qqwryDB{db: db, language: language, tree: body[4+metaLength:], nodeCount: meta.NodeCount}
+	if len(d.tree) < d.nodeCount*8 {
+		return nil, fmt.Errorf("IPDB node tree truncated")
+	}
+
+	// IPv4 lookups start at the node of ::ffff:0:0/96
+	for i := 0; i < 96 && d.v4offset < d.nodeCount; i++ {
+		d.v4offset = d.readNode(d.v4offset, i >= 80)
+	}
+	return d, nil
+}
+
+// readNode returns the left or right child of node
+func (d *qqwryDB) readNode(node int, right bool) int {
+	off := node * 8
+	if right {
+		off += 4
+	}
+	return int(binary.BigEndian.Uint32(d.tree[off : off+4]))
+}
+
+// prefixBits returns the length of the tree path ending at the record of
+// ip, i.e. the prefix length of the range the record covers. An address
+// without a record gets its full length.
+func (d *qqwryDB) prefixBits(ip net.IP) int {
+	node, bitCount := 0, 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, node, bitCount = ip4, d.v4offset, 32
+	} else {
+		ip = ip.To16()
+	}
+
+	for i := 0; i < bitCount; i++ {
+		if node > d.nodeCount {
+			return i
+		}
+		if node == d.nodeCount {
+			break
+		}
+		node = d.readNode(node, ip[i>>3]>>(7-i%8)&1 == 1)
+	}
+	return bitCount
 }
 
-// Close closes the database (no-op for ipdb, but maintains interface consistency)
+// dbFor returns the database covering ip's address family, or nil
+func (r *QQWryReader) dbFor(ip net.IP) *qqwryDB {
+	if ip.To4() != nil {
+		return r.v4
+	}
+	return r.v6
+}
+
+// Close closes the databases (no-op for ipdb, but maintains interface consistency)
 func (r *QQWryReader) Close() error {
 	// ipdb.City does not have a Close method, data is loaded into memory
 	return nil
@@ -50,7 +179,11 @@ func (r *QQWryReader) Lookup(ip net.IP) (*QQWryRecord, error) {
 
 // LookupTo looks up an IP address into a pre-allocated record to reduce allocations
 func (r *QQWryReader) LookupTo(ip net.IP, record *QQWryRecord) error {
-	info, err := r.db.FindInfo(ip.String(), "CN")
+	db := r.dbFor(ip)
+	if db == nil {
+		return errQQWryUnsupported
+	}
+	info, err := db.db.FindInfo(ip.String(), db.language)
 	if err != nil {
 		return err
 	}
@@ -65,32 +198,37 @@ func (r *QQWryReader) LookupTo(ip net.IP, record *QQWryRecord) error {
 	return nil
 }
 
+// LookupNetworkTo is LookupTo, also returning the network the record covers
+func (r *QQWryReader) LookupNetworkTo(ip net.IP, record *QQWryRecord) (netip.Prefix, error) {
+	if err := r.LookupTo(ip, record); err != nil {
+		return netip.Prefix{}, err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, nil
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, r.dbFor(ip).prefixBits(ip)).Masked(), nil
+}
+
 // LookupString looks up an IP address string in the QQWry database
 func (r *QQWryReader) LookupString(ipStr string) (*QQWryRecord, error) {
-	info, err := r.db.FindInfo(ipStr, "CN")
-	if err != nil {
-		return nil, err
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", ipStr)
 	}
-
-	return &QQWryRecord{
-		CountryName:   info.CountryName,
-		RegionName:    info.RegionName,
-		CityName:      info.CityName,
-		DistrictName:  info.DistrictName,
-		ISPDomain:     info.IspDomain,
-		CountryCode:   info.CountryCode,
-		ContinentCode: info.ContinentCode,
-	}, nil
+	return r.Lookup(ip)
 }
 
 // IsIPv4Supported returns whether the database supports IPv4
 func (r *QQWryReader) IsIPv4Supported() bool {
-	return r.db.IsIPv4()
+	return r.v4 != nil
 }
 
-// IsIPv6Supported returns whether the database supports IPv6
+// IsIPv6Supported returns whether IPv6 is covered, by a combined main
+// database or by the optional IPv6 database
 func (r *QQWryReader) IsIPv6Supported() bool {
-	return r.db.IsIPv6()
+	return r.v6 != nil
 }
 
 // HasGeoData checks if the record has geographic data
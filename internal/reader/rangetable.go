@@ -0,0 +1,188 @@
+package reader
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+
+	"go4.org/netipx"
+)
+
+// prefixValue pairs a prefix with the value it carries
+type prefixValue[T any] struct {
+	prefix netip.Prefix
+	value  T
+}
+
+// valueRange is one disjoint address range of a rangeTable. bits is the
+// length of the most specific prefix covering the range, i.e. the prefix the
+// value came from.
+type valueRange[T any] struct {
+	start netip.Addr
+	end   netip.Addr
+	bits  int
+	value T
+}
+
+// rangeTable is a flattened, non-overlapping view of a set of possibly
+// nested prefixes, built once at load time. Every range carries the value of
+// the most specific prefix covering it, so a lookup is a single binary search
+// regardless of how deeply the input prefixes nest.
+type rangeTable[T any] struct {
+	ranges []valueRange[T]
+}
+
+// buildRangeTable flattens entries into a rangeTable. When the same prefix
+// appears more than once the last entry wins. entries is sorted in place.
+func buildRangeTable[T any](entries []prefixValue[T]) *rangeTable[T] {
+	// Sort by start address, then least specific first so that every prefix
+	// is pushed after the prefixes that contain it
+	slices.SortStableFunc(entries, func(a, b prefixValue[T]) int {
+		if c := a.prefix.Addr().Compare(b.prefix.Addr()); c != 0 {
+			return c
+		}
+		return a.prefix.Bits() - b.prefix.Bits()
+	})
+
+	type open struct {
+		entry *prefixValue[T]
+		end   netip.Addr
+	}
+
+	t := &rangeTable[T]{ranges: make([]valueRange[T], 0, len(entries))}
+	var stack []open
+	var cursor netip.Addr // next address not yet emitted; invalid once the family is exhausted
+
+	emit := func(o open, end netip.Addr) {
+		if !cursor.IsValid() || cursor.Compare(end) > 0 {
+			return
+		}
+		t.ranges = append(t.ranges, valueRange[T]{
+			start: cursor,
+			end:   end,
+			bits:  o.entry.prefix.Bits(),
+			value: o.entry.value,
+		})
+	}
+
+	// pop closes the innermost open prefix, emitting whatever part of it is
+	// not covered by a more specific prefix
+	pop := func() {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		emit(top, top.end)
+		if cursor.IsValid() && cursor.Compare(top.end) <= 0 {
+			cursor = top.end.Next()
+		}
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		prefix := e.prefix.Masked()
+		e.prefix = prefix
+		start := prefix.Addr()
+
+		for len(stack) > 0 && stack[len(stack)-1].end.Less(start) {
+			pop()
+		}
+
+		if len(stack) > 0 && stack[len(stack)-1].entry.prefix == prefix {
+			stack[len(stack)-1].entry = e
+			continue
+		}
+
+		// The enclosing prefix (if any) owns everything up to this one
+		if len(stack) > 0 {
+			emit(stack[len(stack)-1], start.Prev())
+		}
+		cursor = start
+		stack = append(stack, open{entry: e, end: netipx.PrefixLastIP(prefix)})
+	}
+	for len(stack) > 0 {
+		pop()
+	}
+
+	return t
+}
+
+// lookup returns the range containing addr, or nil if addr is not covered
+func (t *rangeTable[T]) lookup(addr netip.Addr) *valueRange[T] {
+	if t == nil || len(t.ranges) == 0 {
+		return nil
+	}
+
+	// Find the first range starting after addr; the candidate is just before it
+	idx := sort.Search(len(t.ranges), func(i int) bool {
+		return addr.Less(t.ranges[i].start)
+	})
+	if idx == 0 {
+		return nil
+	}
+	r := &t.ranges[idx-1]
+	if r.end.Less(addr) {
+		return nil
+	}
+	return r
+}
+
+// len returns the number of disjoint ranges in the table
+func (t *rangeTable[T]) len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.ranges)
+}
+
+// span returns the bounds of the range containing addr or, when addr is not
+// covered, of the gap around it. Either way every address in the bounds
+// gets the same lookup result. The bounds never leave addr's address family.
+func (t *rangeTable[T]) span(addr netip.Addr) (lo, hi netip.Addr) {
+	lo, hi = familyBounds(addr)
+	if t == nil || len(t.ranges) == 0 {
+		return lo, hi
+	}
+
+	idx := sort.Search(len(t.ranges), func(i int) bool {
+		return addr.Less(t.ranges[i].start)
+	})
+	if idx > 0 {
+		r := &t.ranges[idx-1]
+		if !r.end.Less(addr) {
+			return r.start, r.end
+		}
+		if r.end.BitLen() == addr.BitLen() {
+			lo = r.end.Next()
+		}
+	}
+	if idx < len(t.ranges) && t.ranges[idx].start.BitLen() == addr.BitLen() {
+		hi = t.ranges[idx].start.Prev()
+	}
+	return lo, hi
+}
+
+// familyBounds returns the first and last address of addr's family
+func familyBounds(addr netip.Addr) (lo, hi netip.Addr) {
+	if addr.Is4() {
+		return netip.IPv4Unspecified(), netip.AddrFrom4([4]byte{255, 255, 255, 255})
+	}
+	var last [16]byte
+	for i := range last {
+		last[i] = 0xff
+	}
+	return netip.IPv6Unspecified(), netip.AddrFrom16(last)
+}
+
+// narrowPrefix returns the largest prefix containing addr that is no larger
+// than p and lies within [lo, hi]. addr must be within [lo, hi].
+func narrowPrefix(p netip.Prefix, addr netip.Addr, lo, hi netip.Addr) netip.Prefix {
+	for p.Bits() < addr.BitLen() && (p.Addr().Less(lo) || hi.Less(netipx.PrefixLastIP(p))) {
+		p = netip.PrefixFrom(addr, p.Bits()+1).Masked()
+	}
+	return p
+}
+
+// prefixWithin returns the largest prefix containing addr that lies within
+// [lo, hi]
+func prefixWithin(addr netip.Addr, lo, hi netip.Addr) netip.Prefix {
+	return narrowPrefix(netip.PrefixFrom(addr, 0).Masked(), addr, lo, hi)
+}
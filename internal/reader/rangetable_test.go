@@ -0,0 +1,185 @@
+package reader
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+// nestedPrefixes returns n random IPv4 and IPv6 prefixes, a third of them
+// nested inside an earlier prefix, as OpenProxyDB rangeblocks often are
+func nestedPrefixes(n int) []prefixValue[int] {
+	rng := rand.New(rand.NewSource(1))
+	entries := make([]prefixValue[int], 0, n)
+	for i := 0; i < n; i++ {
+		var prefix netip.Prefix
+		switch {
+		case i > 0 && i%3 == 0 && entries[i-1].prefix.Bits() < entries[i-1].prefix.Addr().BitLen():
+			outer := entries[rng.Intn(len(entries))].prefix
+			if outer.IsSingleIP() {
+				outer = entries[i-1].prefix
+			}
+			bits := outer.Bits() + 1 + rng.Intn(outer.Addr().BitLen()-outer.Bits())
+			prefix = netip.PrefixFrom(randomAddrIn(rng, outer), bits).Masked()
+		case i%2 == 0:
+			var a [4]byte
+			rng.Read(a[:])
+			prefix = netip.PrefixFrom(netip.AddrFrom4(a), 8+rng.Intn(17)).Masked()
+		default:
+			var a [16]byte
+			rng.Read(a[:])
+			prefix = netip.PrefixFrom(netip.AddrFrom16(a), 16+rng.Intn(49)).Masked()
+		}
+		entries = append(entries, prefixValue[int]{prefix: prefix, value: i})
+	}
+	return entries
+}
+
+// randomAddrIn returns a random address within prefix
+func randomAddrIn(rng *rand.Rand, prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	random := make([]byte, len(b))
+	rng.Read(random)
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		mask := byte(1) << (7 - i%8)
+		b[i/8] = b[i/8]&^mask | random[i/8]&mask
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// probeAddrs returns n addresses, most of them inside one of entries
+func probeAddrs(entries []prefixValue[int], n int) []netip.Addr {
+	rng := rand.New(rand.NewSource(2))
+	addrs := make([]netip.Addr, n)
+	for i := range addrs {
+		if i%4 == 0 {
+			var a [4]byte
+			rng.Read(a[:])
+			addrs[i] = netip.AddrFrom4(a)
+			continue
+		}
+		addrs[i] = randomAddrIn(rng, entries[rng.Intn(len(entries))].prefix)
+	}
+	return addrs
+}
+
+// mostSpecific is the reference lookup: a scan for the longest prefix
+// containing addr, the last one listed winning among duplicates
+func mostSpecific(entries []prefixValue[int], addr netip.Addr) (int, int, bool) {
+	value, bits, found := 0, -1, false
+	for _, e := range entries {
+		if e.prefix.Contains(addr) && e.prefix.Bits() >= bits {
+			value, bits, found = e.value, e.prefix.Bits(), true
+		}
+	}
+	return value, bits, found
+}
+
+func TestRangeTableMostSpecific(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		addr    string
+		want    int
+		found   bool
+	}{
+		{"no prefixes", nil, "192.0.2.1", 0, false},
+		{"outside", []string{"192.0.2.0/24"}, "198.51.100.1", 0, false},
+		{"single", []string{"192.0.2.0/24"}, "192.0.2.200", 0, true},
+		{"nested inner", []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24"}, "10.1.2.3", 2, true},
+		{"nested middle", []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24"}, "10.1.3.3", 1, true},
+		{"nested outer after inner", []string{"10.0.0.0/8", "10.1.0.0/16"}, "10.2.0.0", 0, true},
+		{"duplicate last wins", []string{"10.0.0.0/8", "10.0.0.0/8"}, "10.0.0.1", 1, true},
+		{"ipv6 not ipv4", []string{"::/0"}, "192.0.2.1", 0, false},
+		{"ipv6 nested", []string{"2001:db8::/32", "2001:db8:1::/48"}, "2001:db8:1::1", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []prefixValue[int]
+			for i, p := range tt.entries {
+				entries = append(entries, prefixValue[int]{prefix: netip.MustParsePrefix(p), value: i})
+			}
+			match := buildRangeTable(entries).lookup(netip.MustParseAddr(tt.addr))
+			if (match != nil) != tt.found {
+				t.Fatalf("found = %v, want %v", match != nil, tt.found)
+			}
+			if match != nil && match.value != tt.want {
+				t.Errorf("value = %d, want %d", match.value, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeTableMatchesScan(t *testing.T) {
+	entries := nestedPrefixes(2000)
+	reference := append([]prefixValue[int](nil), entries...)
+	table := buildRangeTable(entries)
+
+	for _, addr := range probeAddrs(reference, 20000) {
+		value, bits, found := mostSpecific(reference, addr)
+		match := table.lookup(addr)
+		if (match != nil) != found {
+			t.Fatalf("%s: found = %v, want %v", addr, match != nil, found)
+		}
+		if match != nil && (match.value != value || match.bits != bits) {
+			t.Fatalf("%s: got value %d /%d, want %d /%d", addr, match.value, match.bits, value, bits)
+		}
+	}
+}
+
+func BenchmarkBuildRangeTable(b *testing.B) {
+	entries := nestedPrefixes(100000)
+	work := make([]prefixValue[int], len(entries))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(work, entries)
+		buildRangeTable(work)
+	}
+}
+
+func BenchmarkRangeTableLookup(b *testing.B) {
+	for _, size := range []int{1000, 100000} {
+		entries := nestedPrefixes(size)
+		addrs := probeAddrs(entries, 4096)
+		table := buildRangeTable(entries)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				table.lookup(addrs[i%len(addrs)])
+			}
+		})
+	}
+}
+
+// BenchmarkOpenproxyDBLookupTo measures the full enrichment lookup: the
+// single IP map first, then the CIDR range table
+func BenchmarkOpenproxyDBLookupTo(b *testing.B) {
+	entries := nestedPrefixes(100000)
+	r := &OpenproxyDBReader{singleIPs: make(map[netip.Addr]OpenproxyDBRecord)}
+	for _, e := range entries {
+		r.cidrRanges = append(r.cidrRanges, prefixValue[OpenproxyDBRecord]{
+			prefix: e.prefix,
+			value:  OpenproxyDBRecord{IsProxy: true, Sources: SourceOpenProxyDB},
+		})
+	}
+	addrs := probeAddrs(entries, 4096)
+	for _, addr := range addrs[:len(addrs)/8] {
+		r.singleIPs[addr] = OpenproxyDBRecord{IsVPN: true, Sources: SourceOpenProxyDB}
+	}
+	r.cidrTable = buildRangeTable(r.cidrRanges)
+
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.AsSlice()
+	}
+
+	var record OpenproxyDBRecord
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		record.Reset()
+		r.LookupTo(ips[i%len(ips)], &record)
+	}
+}
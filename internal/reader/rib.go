@@ -0,0 +1,439 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MRT (RFC 6396) record types and TABLE_DUMP_V2 subtypes carrying unicast
+// routes; the ADDPATH variants are from RFC 8050
+const (
+	mrtTypeTableDumpV2              = 13
+	mrtSubtypeRIBIPv4Unicast        = 2
+	mrtSubtypeRIBIPv6Unicast        = 4
+	mrtSubtypeRIBIPv4UnicastAddPath = 8
+	mrtSubtypeRIBIPv6UnicastAddPath = 10
+
+	mrtHeaderLen    = 12
+	mrtMaxRecordLen = 16 << 20
+
+	bgpAttrFlagExtendedLength = 0x10
+	bgpAttrASPath             = 2
+	asPathSegmentSet          = 1
+	asPathSegmentSequence     = 2
+)
+
+// RIBRecord is the origin of the most specific routed prefix containing an
+// address. Origins has more than one ASN for a MOAS prefix (originated by
+// several ASes), most commonly seen first.
+type RIBRecord struct {
+	Network netip.Prefix // aligned block around the address with the same origins
+	Bits    int          // length of the routed prefix the origins come from
+	Origins []uint32
+}
+
+// RIBReader maps routed prefixes to their origin ASNs, parsed from local
+// CAIDA pfx2as files or MRT TABLE_DUMP_V2 RIB dumps, as an ASN source
+// independent of the packaged databases
+type RIBReader struct {
+	table *rangeTable[[]uint32]
+
+	prefixes     int
+	moasPrefixes int
+	malformed    int
+}
+
+// originCount counts the routes originating a prefix from one ASN
+type originCount struct {
+	asn   uint32
+	count int
+}
+
+// OpenRIB parses every file in paths. Each may be a pfx2as text file or an
+// MRT RIB dump, optionally gzip or bzip2 compressed; the format is detected
+// from the content. Routes for the same prefix from several files are
+// combined. Malformed pfx2as lines are skipped and counted; a malformed MRT
+// record is an error, since the rest of the dump cannot be trusted.
+func OpenRIB(paths []string) (*RIBReader, error) {
+	byPrefix := make(map[netip.Prefix][]originCount)
+	add := func(prefix netip.Prefix, origins []uint32) {
+		prefix = prefix.Masked()
+		counts := byPrefix[prefix]
+		for _, asn := range origins {
+			if asn == 0 {
+				continue
+			}
+			if i := slices.IndexFunc(counts, func(c originCount) bool { return c.asn == asn }); i >= 0 {
+				counts[i].count++
+			} else {
+				counts = append(counts, originCount{asn: asn, count: 1})
+			}
+		}
+		byPrefix[prefix] = counts
+	}
+
+	r := &RIBReader{}
+	for _, path := range paths {
+		malformed, err := parseRIBFile(path, add)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RIB file %s: %w", path, err)
+		}
+		r.malformed += malformed
+	}
+
+	entries := make([]prefixValue[[]uint32], 0, len(byPrefix))
+	for prefix, counts := range byPrefix {
+		if len(counts) == 0 {
+			continue
+		}
+		// Stable order: most routes first, then lowest ASN
+		slices.SortFunc(counts, func(a, b originCount) int {
+			if a.count != b.count {
+				return b.count - a.count
+			}
+			return cmp.Compare(a.asn, b.asn)
+		})
+		origins := make([]uint32, len(counts))
+		for i, c := range counts {
+			origins[i] = c.asn
+		}
+		entries = append(entries, prefixValue[[]uint32]{prefix: prefix, value: origins})
+		if len(origins) > 1 {
+			r.moasPrefixes++
+		}
+	}
+	r.prefixes = len(entries)
+	r.table = buildRangeTable(entries)
+
+	return r, nil
+}
+
+// parseRIBFile detects the compression and format of the file at path and
+// passes every prefix and its origins to add. Returns the number of
+// malformed lines skipped.
+func parseRIBFile(path string, add func(prefix netip.Prefix, origins []uint32)) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	magic, _ := r.(*bufio.Reader).Peek(3)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(r)
+	}
+
+	buffered := bufio.NewReaderSize(r, 1<<20)
+	header, _ := buffered.Peek(mrtHeaderLen)
+	// A text line never has a NUL byte where an MRT header has its type
+	if len(header) == mrtHeaderLen && binary.BigEndian.Uint16(header[4:6]) == mrtTypeTableDumpV2 {
+		return 0, parseMRT(buffered, add)
+	}
+	return parsePfx2as(buffered, add)
+}
+
+// parsePfx2as parses the CAIDA Routeviews prefix-to-AS format: one
+// "address<TAB>length<TAB>origins" line per prefix, where MOAS origins are
+// separated by '_' and AS sets by ','. Lines that do not parse, or name
+// no valid origin, are skipped; their count is returned.
+func parsePfx2as(r io.Reader, add func(prefix netip.Prefix, origins []uint32)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	var origins []uint32
+	malformed := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			malformed++
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			malformed++
+			continue
+		}
+		bits, err := strconv.Atoi(fields[1])
+		if err != nil {
+			malformed++
+			continue
+		}
+		prefix, err := addr.Unmap().Prefix(bits)
+		if err != nil {
+			malformed++
+			continue
+		}
+
+		origins = origins[:0]
+		for _, field := range strings.FieldsFunc(fields[2], func(r rune) bool { return r == '_' || r == ',' }) {
+			if asn, ok := parseASNField(field); ok && !slices.Contains(origins, asn) {
+				origins = append(origins, asn)
+			}
+		}
+		if len(origins) == 0 {
+			malformed++
+			continue
+		}
+		add(prefix, origins)
+	}
+	return malformed, scanner.Err()
+}
+
+// parseMRT parses the unicast RIB records of an MRT TABLE_DUMP_V2 dump.
+// Every RIB entry (one per peer) contributes the origin of its AS path;
+// other record types, such as the peer index table, are skipped.
+func parseMRT(r io.Reader, add func(prefix netip.Prefix, origins []uint32)) error {
+	header := make([]byte, mrtHeaderLen)
+	var body []byte
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("truncated MRT header: %w", err)
+		}
+		mrtType := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		if length > mrtMaxRecordLen {
+			return fmt.Errorf("MRT record of %d bytes exceeds the %d byte limit", length, mrtMaxRecordLen)
+		}
+
+		if cap(body) < int(length) {
+			body = make([]byte, length)
+		}
+		body = body[:length]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("truncated MRT record: %w", err)
+		}
+		if mrtType != mrtTypeTableDumpV2 {
+			continue
+		}
+
+		var ipv6, addPath bool
+		switch subtype {
+		case mrtSubtypeRIBIPv4Unicast:
+		case mrtSubtypeRIBIPv6Unicast:
+			ipv6 = true
+		case mrtSubtypeRIBIPv4UnicastAddPath:
+			addPath = true
+		case mrtSubtypeRIBIPv6UnicastAddPath:
+			ipv6, addPath = true, true
+		default:
+			continue
+		}
+
+		if err := parseMRTRIB(body, ipv6, addPath, add); err != nil {
+			return err
+		}
+	}
+}
+
+// errMRTTruncated reports a RIB record shorter than its contents claim
+var errMRTTruncated = errors.New("truncated MRT RIB record")
+
+// parseMRTRIB parses one RIB_IPV4_UNICAST / RIB_IPV6_UNICAST record body
+// (RFC 6396 section 4.3.2) and adds the origins of all its entries
+func parseMRTRIB(body []byte, ipv6, addPath bool, add func(prefix netip.Prefix, origins []uint32)) error {
+	// Sequence number, then the prefix length and the prefix bytes
+	if len(body) < 5 {
+		return errMRTTruncated
+	}
+	bits := int(body[4])
+	body = body[5:]
+	n := (bits + 7) / 8
+	if len(body) < n {
+		return errMRTTruncated
+	}
+	var addr netip.Addr
+	if ipv6 {
+		var a [16]byte
+		if n > len(a) {
+			return fmt.Errorf("invalid IPv6 prefix length %d", bits)
+		}
+		copy(a[:], body[:n])
+		addr = netip.AddrFrom16(a)
+	} else {
+		var a [4]byte
+		if n > len(a) {
+			return fmt.Errorf("invalid IPv4 prefix length %d", bits)
+		}
+		copy(a[:], body[:n])
+		addr = netip.AddrFrom4(a)
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return err
+	}
+	body = body[n:]
+
+	if len(body) < 2 {
+		return errMRTTruncated
+	}
+	count := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+
+	var origins []uint32
+	for i := 0; i < count; i++ {
+		// Peer index (2), originated time (4), path identifier (4, ADDPATH
+		// only), attribute length (2)
+		skip := 6
+		if addPath {
+			skip += 4
+		}
+		if len(body) < skip+2 {
+			return errMRTTruncated
+		}
+		attrLen := int(binary.BigEndian.Uint16(body[skip:]))
+		body = body[skip+2:]
+		if len(body) < attrLen {
+			return errMRTTruncated
+		}
+		origins = append(origins, bgpOrigins(body[:attrLen])...)
+		body = body[attrLen:]
+	}
+
+	if len(origins) > 0 {
+		add(prefix, origins)
+	}
+	return nil
+}
+
+// bgpOrigins returns the origin ASNs in the AS_PATH attribute among attrs:
+// the last ASN of the path, or every member when the path ends in an AS_SET.
+// TABLE_DUMP_V2 always encodes AS_PATH with 4-byte ASNs.
+func bgpOrigins(attrs []byte) []uint32 {
+	for len(attrs) >= 3 {
+		flags, attrType := attrs[0], attrs[1]
+		hdr, length := 3, int(attrs[2])
+		if flags&bgpAttrFlagExtendedLength != 0 {
+			if len(attrs) < 4 {
+				return nil
+			}
+			hdr, length = 4, int(binary.BigEndian.Uint16(attrs[2:]))
+		}
+		if len(attrs) < hdr+length {
+			return nil
+		}
+		if attrType == bgpAttrASPath {
+			return asPathOrigins(attrs[hdr : hdr+length])
+		}
+		attrs = attrs[hdr+length:]
+	}
+	return nil
+}
+
+// asPathOrigins returns the origins of an AS_PATH attribute value.
+// Confederation segments are internal to the neighbor and skipped.
+func asPathOrigins(path []byte) []uint32 {
+	var origins []uint32
+	for len(path) >= 2 {
+		segType, n := path[0], int(path[1])
+		path = path[2:]
+		if len(path) < 4*n {
+			return nil
+		}
+		switch segType {
+		case asPathSegmentSequence:
+			if n > 0 {
+				origins = append(origins[:0], binary.BigEndian.Uint32(path[4*(n-1):]))
+			}
+		case asPathSegmentSet:
+			origins = origins[:0]
+			for i := 0; i < n; i++ {
+				origins = append(origins, binary.BigEndian.Uint32(path[4*i:]))
+			}
+		}
+		path = path[4*n:]
+	}
+	return origins
+}
+
+// LookupTo looks up an IP address into a pre-allocated record.
+// Returns true if the address is in a routed prefix.
+func (r *RIBReader) LookupTo(ip net.IP, record *RIBRecord) bool {
+	_, found := r.LookupNetworkTo(ip, record)
+	return found
+}
+
+// LookupNetworkTo is LookupTo, also returning the largest prefix around ip
+// with the same answer: record.Network when the address is routed, or a
+// prefix of the unrouted gap around it otherwise
+func (r *RIBReader) LookupNetworkTo(ip net.IP, record *RIBRecord) (netip.Prefix, bool) {
+	if r == nil {
+		return netip.Prefix{}, false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	match := r.table.lookup(addr)
+	if match == nil {
+		lo, hi := r.table.span(addr)
+		return prefixWithin(addr, lo, hi), false
+	}
+
+	record.Origins = match.value
+	record.Bits = match.bits
+	record.Network = prefixWithin(addr, match.start, match.end)
+	return record.Network, true
+}
+
+// Count returns the number of routed prefixes and how many of them are MOAS
+func (r *RIBReader) Count() (prefixes, moas int) {
+	if r == nil {
+		return 0, 0
+	}
+	return r.prefixes, r.moasPrefixes
+}
+
+// Malformed returns the number of pfx2as lines skipped because they did not
+// parse
+func (r *RIBReader) Malformed() int {
+	if r == nil {
+		return 0
+	}
+	return r.malformed
+}
+
+// HasOrigin checks if the record has an origin ASN
+func (r *RIBRecord) HasOrigin() bool {
+	return len(r.Origins) > 0
+}
+
+// Reset clears all fields for reuse
+func (r *RIBRecord) Reset() {
+	r.Network = netip.Prefix{}
+	r.Origins = nil
+	r.Bits = 0
+}
+
+// Close closes the reader (no-op as data is in memory)
+func (r *RIBReader) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package reader
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// The fixtures in testdata are small hand-built dumps: pfx2as.txt (and its
+// gzip copy) has valid, MOAS, AS set and malformed lines; rib.mrt is a
+// TABLE_DUMP_V2 dump with a peer index table, IPv4 and IPv6 unicast records,
+// an ADDPATH record and a BGP4MP record the parser skips.
+
+func TestOpenRIB(t *testing.T) {
+	type lookup struct {
+		addr    string
+		network string
+		bits    int
+		origins []uint32
+	}
+	tests := []struct {
+		name      string
+		files     []string
+		prefixes  int
+		moas      int
+		malformed int
+		lookups   []lookup
+	}{
+		{
+			name:      "pfx2as",
+			files:     []string{"pfx2as.txt"},
+			prefixes:  6,
+			moas:      2,
+			malformed: 5,
+			lookups: []lookup{
+				{"1.0.0.1", "1.0.0.0/24", 24, []uint32{13335}},
+				{"192.0.2.1", "192.0.2.0/24", 24, []uint32{64500, 64501}},
+				{"198.51.100.200", "198.51.100.128/25", 24, []uint32{64502, 64503}},
+				{"198.51.100.1", "198.51.100.0/25", 25, []uint32{64504}},
+				{"2001:db8::1", "2001:db8::/32", 32, []uint32{64510}},
+				{"10.0.0.1", "", 0, nil},
+				{"10.3.0.1", "", 0, nil},
+			},
+		},
+		{
+			name:      "pfx2as gzip",
+			files:     []string{"pfx2as.txt.gz"},
+			prefixes:  6,
+			moas:      2,
+			malformed: 5,
+			lookups: []lookup{
+				{"8.8.8.8", "8.8.8.0/24", 24, []uint32{15169}},
+			},
+		},
+		{
+			name:     "mrt",
+			files:    []string{"rib.mrt"},
+			prefixes: 5,
+			moas:     2,
+			lookups: []lookup{
+				{"1.0.0.1", "1.0.0.0/24", 24, []uint32{13335}},
+				{"8.8.8.8", "8.8.8.0/24", 24, []uint32{15169, 64505}},
+				{"192.0.2.1", "192.0.2.0/24", 24, []uint32{64500, 64501}},
+				{"2001:db8::1", "2001:db8::/32", 32, []uint32{64510}},
+				{"198.51.100.1", "198.51.100.0/24", 24, []uint32{64502}},
+			},
+		},
+		{
+			name:      "combined",
+			files:     []string{"pfx2as.txt", "rib.mrt"},
+			prefixes:  6,
+			moas:      3,
+			malformed: 5,
+			lookups: []lookup{
+				// 64502 is seen by both files, 64503 only in pfx2as
+				{"198.51.100.200", "198.51.100.128/25", 24, []uint32{64502, 64503}},
+				{"8.8.8.8", "8.8.8.0/24", 24, []uint32{15169, 64505}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			for _, f := range tt.files {
+				paths = append(paths, filepath.Join("testdata", f))
+			}
+			r, err := OpenRIB(paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prefixes, moas := r.Count(); prefixes != tt.prefixes || moas != tt.moas {
+				t.Errorf("Count() = %d, %d, want %d, %d", prefixes, moas, tt.prefixes, tt.moas)
+			}
+			if got := r.Malformed(); got != tt.malformed {
+				t.Errorf("Malformed() = %d, want %d", got, tt.malformed)
+			}
+
+			for _, l := range tt.lookups {
+				var record RIBRecord
+				found := r.LookupTo(netip.MustParseAddr(l.addr).AsSlice(), &record)
+				if found != (l.origins != nil) {
+					t.Errorf("%s: found = %v, want %v", l.addr, found, l.origins != nil)
+					continue
+				}
+				if !found {
+					continue
+				}
+				if record.Network != netip.MustParsePrefix(l.network) || record.Bits != l.bits {
+					t.Errorf("%s: network %s /%d, want %s /%d", l.addr, record.Network, record.Bits, l.network, l.bits)
+				}
+				if !slices.Equal(record.Origins, l.origins) {
+					t.Errorf("%s: origins %v, want %v", l.addr, record.Origins, l.origins)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenRIBTruncatedMRT(t *testing.T) {
+	dump, err := os.ReadFile(filepath.Join("testdata", "rib.mrt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "truncated.mrt")
+	if err := os.WriteFile(path, dump[:len(dump)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRIB([]string{path}); err == nil {
+		t.Error("OpenRIB accepted a truncated MRT dump")
+	}
+}
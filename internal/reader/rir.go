@@ -0,0 +1,261 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"merged-ip-data/internal/config"
+
+	"go4.org/netipx"
+)
+
+// Regional Internet Registry names emitted as registration.rir, as used in
+// the delegation files
+const (
+	RIRARIN    = "arin"
+	RIRRIPE    = "ripencc"
+	RIRAPNIC   = "apnic"
+	RIRLACNIC  = "lacnic"
+	RIRAFRINIC = "afrinic"
+)
+
+// rirRegistries lists every registry; unallocated space can only be derived
+// when all of them contributed delegations
+var rirRegistries = []string{RIRARIN, RIRRIPE, RIRAPNIC, RIRLACNIC, RIRAFRINIC}
+
+// RIRRecord describes the delegation of an address block by a Regional
+// Internet Registry
+type RIRRecord struct {
+	Registry    string
+	CountryCode string // ISO 3166-1 code of the holder; empty when not given
+	Date        string // allocation or assignment date as YYYY-MM-DD; empty when not given
+	Bits        int    // length of the delegated prefix containing the address; set by LookupTo
+}
+
+// RIRDelegations holds the address blocks allocated or assigned in the RIR
+// delegated-extended statistics files. All input files are optional; a
+// registry whose file is missing simply contributes no blocks.
+type RIRDelegations struct {
+	table *rangeTable[RIRRecord]
+
+	// registryCounts is the number of delegations loaded per registry
+	registryCounts map[string]int
+}
+
+// OpenRIRDelegations loads every RIR delegation file present on disk
+func OpenRIRDelegations() (*RIRDelegations, error) {
+	files := []struct {
+		registry string
+		path     string
+	}{
+		{RIRARIN, config.ARINDelegatedFile},
+		{RIRRIPE, config.RIPEDelegatedFile},
+		{RIRAPNIC, config.APNICDelegatedFile},
+		{RIRLACNIC, config.LACNICDelegatedFile},
+		{RIRAFRINIC, config.AFRINICDelegatedFile},
+	}
+
+	d := &RIRDelegations{registryCounts: make(map[string]int)}
+	var entries []prefixValue[RIRRecord]
+
+	for _, f := range files {
+		file, err := os.Open(f.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to open %s delegations: %w", f.registry, err)
+		}
+
+		count, err := parseRIRDelegations(file, f.registry, func(prefix netip.Prefix, rec RIRRecord) {
+			entries = append(entries, prefixValue[RIRRecord]{prefix: prefix, value: rec})
+		})
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s delegations: %w", f.registry, err)
+		}
+		d.registryCounts[f.registry] = count
+	}
+
+	d.table = buildRangeTable(entries)
+	return d, nil
+}
+
+// parseRIRDelegations parses a delegated-extended file:
+//
+//	registry|cc|type|start|value|date|status[|opaque-id[|extensions...]]
+//
+// Only allocated and assigned ipv4/ipv6 records are kept. For ipv4, value is
+// the number of addresses, which need not be a power of two, so the block is
+// split into prefixes; for ipv6 it is the prefix length. The version header
+// and summary lines are skipped. Returns the number of delegations added.
+func parseRIRDelegations(file *os.File, registry string, add func(prefix netip.Prefix, rec RIRRecord)) (int, error) {
+	// Country codes repeat on every line; share one string per code
+	codes := make(map[string]string)
+
+	count := 0
+	scanner := bufio.NewScanner(bufio.NewReader(file))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 7 {
+			continue
+		}
+		status := fields[6]
+		if status != "allocated" && status != "assigned" {
+			continue
+		}
+
+		var prefixes []netip.Prefix
+		switch fields[2] {
+		case "ipv4":
+			start, err := netip.ParseAddr(fields[3])
+			if err != nil || !start.Is4() {
+				continue
+			}
+			size, err := strconv.ParseUint(fields[4], 10, 32)
+			a := start.As4()
+			first := binary.BigEndian.Uint32(a[:])
+			if err != nil || size == 0 || size-1 > math.MaxUint32-uint64(first) {
+				continue
+			}
+			binary.BigEndian.PutUint32(a[:], first+uint32(size-1))
+			end := netip.AddrFrom4(a)
+			prefixes = netipx.IPRangeFrom(start, end).Prefixes()
+		case "ipv6":
+			start, err := netip.ParseAddr(fields[3])
+			if err != nil || !start.Is6() {
+				continue
+			}
+			length, err := strconv.Atoi(fields[4])
+			if err != nil {
+				continue
+			}
+			prefix, err := start.Prefix(length)
+			if err != nil {
+				continue
+			}
+			prefixes = []netip.Prefix{prefix}
+		default:
+			continue
+		}
+
+		rec := RIRRecord{Registry: registry, Date: rirDate(fields[5])}
+		if cc := strings.ToUpper(fields[1]); isRIRCountryCode(cc) {
+			if shared, ok := codes[cc]; ok {
+				cc = shared
+			} else {
+				codes[cc] = cc
+			}
+			rec.CountryCode = cc
+		}
+
+		for _, prefix := range prefixes {
+			add(prefix, rec)
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// isRIRCountryCode reports whether cc is a country code rather than one of
+// the placeholders the registries use for unknown or regional holders
+func isRIRCountryCode(cc string) bool {
+	if len(cc) != 2 {
+		return false
+	}
+	switch cc {
+	case "ZZ", "EU", "AP":
+		return false
+	}
+	return true
+}
+
+// rirDate converts a YYYYMMDD delegation date to YYYY-MM-DD, or returns ""
+// for the empty and all-zero placeholders
+func rirDate(s string) string {
+	if len(s) != 8 || s == "00000000" {
+		return ""
+	}
+	return s[0:4] + "-" + s[4:6] + "-" + s[6:8]
+}
+
+// LookupTo looks up an IP address into a pre-allocated record.
+// Returns true if the address is in a delegated block.
+func (d *RIRDelegations) LookupTo(ip net.IP, record *RIRRecord) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	match := d.table.lookup(addr.Unmap())
+	if match == nil {
+		return false
+	}
+	*record = match.value
+	record.Bits = match.bits
+	return true
+}
+
+// Unallocated returns the IPv4 space and the IPv6 global unicast space
+// (2000::/3) not allocated or assigned by any registry. The result is only
+// meaningful when the files of all five registries were loaded and each
+// held delegations; ok is false otherwise.
+func (d *RIRDelegations) Unallocated() (set *netipx.IPSet, ok bool, err error) {
+	for _, registry := range rirRegistries {
+		if d.registryCounts[registry] == 0 {
+			return nil, false, nil
+		}
+	}
+
+	var builder netipx.IPSetBuilder
+	builder.AddPrefix(netip.MustParsePrefix("0.0.0.0/0"))
+	builder.AddPrefix(netip.MustParsePrefix("2000::/3"))
+	for _, r := range d.table.ranges {
+		builder.RemoveRange(netipx.IPRangeFrom(r.start, r.end))
+	}
+
+	set, err = builder.IPSet()
+	if err != nil {
+		return nil, false, err
+	}
+	return set, true, nil
+}
+
+// RegistryCounts returns the number of delegations loaded per registry
+func (d *RIRDelegations) RegistryCounts() map[string]int {
+	return d.registryCounts
+}
+
+// Count returns the number of disjoint delegated ranges
+func (d *RIRDelegations) Count() int {
+	return d.table.len()
+}
+
+// HasData checks if the record names a registry
+func (r *RIRRecord) HasData() bool {
+	return r.Registry != ""
+}
+
+// Reset clears all fields for reuse
+func (r *RIRRecord) Reset() {
+	r.Registry = ""
+	r.CountryCode = ""
+	r.Date = ""
+	r.Bits = 0
+}
+
+// Close closes the reader (no-op as data is in memory)
+func (d *RIRDelegations) Close() error {
+	return nil
+}
@@ -41,6 +41,12 @@ func (r *RouteViewsASNReader) LookupTo(ip net.IP, record *RouteViewsASNRecord) e
 	return r.Reader.Lookup(ip, record)
 }
 
+// LookupNetworkTo looks up an IP address into a pre-allocated record and
+// returns the network the record belongs to
+func (r *RouteViewsASNReader) LookupNetworkTo(ip net.IP, record *RouteViewsASNRecord) (*net.IPNet, bool, error) {
+	return r.Reader.LookupNetwork(ip, record)
+}
+
 // LookupNetwork looks up an IP and returns the network and record
 func (r *RouteViewsASNReader) LookupNetwork(ip net.IP) (*net.IPNet, *RouteViewsASNRecord, bool, error) {
 	var record RouteViewsASNRecord